@@ -0,0 +1,35 @@
+package v1
+
+// AggregateSpec is the specification of an aggregate. An aggregate renders
+// each of its outputs once, with the resources matching any of its selectors
+// in the Selected field of the context.
+type AggregateSpec struct {
+	Name        string            `yaml:"name"`
+	Selectors   []SelectorSpec    `yaml:"selectors"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	Outputs     []OutputSpec      `yaml:"outputs"`
+}
+
+// ValidAggregateSpecFields is the list of valid fields in an AggregateSpec.
+var ValidAggregateSpecFields = []string{"name", "selectors", "labels", "annotations", "outputs"}
+
+// Matches returns true if the resource spec is selected by any of the
+// selectors of the aggregate.
+func (a *AggregateSpec) Matches(r *ResourceSpec) bool {
+	for n := range a.Selectors {
+		if a.Selectors[n].Matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregate represents a Tpology aggregate
+type Aggregate struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Aggregate  AggregateSpec `yaml:"aggregate"`
+}
+
+// ValidAggregateFields is the list of valid fields in an Aggregate.
+var ValidAggregateFields = []string{"apiVersion", "aggregate"}
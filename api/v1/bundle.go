@@ -0,0 +1,16 @@
+package v1
+
+// Bundle is a whole model in a single document, as exported from an Index.
+// Documents are sorted by kind and name.
+type Bundle struct {
+	APIVersion   string           `yaml:"apiVersion"`
+	Resources    []ResourceSpec   `yaml:"resources"`
+	Templates    []TemplateSpec   `yaml:"templates"`
+	Repositories []RepositorySpec `yaml:"repositories"`
+	Generators   []GeneratorSpec  `yaml:"generators"`
+	Aggregates   []AggregateSpec  `yaml:"aggregates"`
+	Policies     []PolicySpec     `yaml:"policies"`
+	// Sources are where each document was loaded from, keyed by the ID of
+	// the document, such as `resource:kind/name` or `template:name`.
+	Sources map[string][]SourceSpec `yaml:"sources"`
+}
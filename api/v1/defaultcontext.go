@@ -3,13 +3,39 @@ package v1
 // DefaultContext is the context passed to a template if some other context
 // is not specified.
 type DefaultContext struct {
-	// Self is the resource spec of the resource generating the output.
+	// Self is the resource spec of the resource generating the output, or the
+	// aggregate spec of an aggregate output.
 	Self interface{} `yaml:"self"`
+	// Selected is the list of resource specs selected by an aggregate, in the
+	// same form as Self, sorted by kind and name.
+	Selected []interface{} `yaml:"selected"`
+	// Namespace is the namespace of the resource generating the output, or
+	// empty for the default namespace.
+	Namespace string `yaml:"namespace"`
 	// Resources is a map of all the resource specs, keyed by kind and then
+	// name. The names of resources in a namespace are qualified, as in
+	// `namespace/name`.
+	Resources map[string]map[string]*ResourceSpec `yaml:"resources"`
+	// Templates is a map of all the template specs, keyed by qualified name.
+	Templates map[string]*TemplateSpec `yaml:"templates"`
+	// Repositories is a map of all the repository specs, keyed by qualified
 	// name.
+	Repositories map[string]*RepositorySpec `yaml:"repositories"`
+	// Namespaces are the documents of each namespace, keyed by namespace,
+	// with the default namespace keyed by the empty string.
+	Namespaces map[string]*NamespaceContext `yaml:"namespaces"`
+}
+
+// NamespaceContext is the part of the context holding the documents of a
+// namespace, keyed by their names in the namespace.
+type NamespaceContext struct {
+	// Resources is a map of the resource specs of the namespace, keyed by
+	// kind and then name.
 	Resources map[string]map[string]*ResourceSpec `yaml:"resources"`
-	// Templates is a map of all the template specs, keyed by name.
+	// Templates is a map of the template specs of the namespace, keyed by
+	// name.
 	Templates map[string]*TemplateSpec `yaml:"templates"`
-	// Repositories is a map of all the repository specs, keyed by name.
+	// Repositories is a map of the repository specs of the namespace, keyed
+	// by name.
 	Repositories map[string]*RepositorySpec `yaml:"repositories"`
 }
@@ -0,0 +1,52 @@
+package v1
+
+// SelectorSpec selects resources by kind and labels.
+type SelectorSpec struct {
+	// Kind is the kind of the selected resources.
+	Kind string `yaml:"kind"`
+	// Labels are the labels the selected resources must all have.
+	Labels map[string]string `yaml:"labels"`
+	// Namespace is the namespace of the selected resources. Resources of
+	// every namespace are selected if it is empty.
+	Namespace string `yaml:"namespace"`
+}
+
+// ValidSelectorSpecFields is the list of valid fields in a SelectorSpec.
+var ValidSelectorSpecFields = []string{"kind", "labels", "namespace"}
+
+// Matches returns true if the resource spec is selected.
+func (s *SelectorSpec) Matches(r *ResourceSpec) bool {
+	if r.Kind != s.Kind || (s.Namespace != "" && r.Namespace != s.Namespace) {
+		return false
+	}
+	for k, v := range s.Labels {
+		if l, ok := r.Labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// GeneratorSpec is the specification of a generator. A generator generates
+// its outputs once for every resource matching its selector, as if each of
+// the resources declared them. The File of an output is a template rendered
+// with the same context as the content.
+type GeneratorSpec struct {
+	Name        string            `yaml:"name"`
+	Selector    SelectorSpec      `yaml:"selector"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	Outputs     []OutputSpec      `yaml:"outputs"`
+}
+
+// ValidGeneratorSpecFields is the list of valid fields in a GeneratorSpec.
+var ValidGeneratorSpecFields = []string{"name", "selector", "labels", "annotations", "outputs"}
+
+// Generator represents a Tpology generator
+type Generator struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Generator  GeneratorSpec `yaml:"generator"`
+}
+
+// ValidGeneratorFields is the list of valid fields in a Generator.
+var ValidGeneratorFields = []string{"apiVersion", "generator"}
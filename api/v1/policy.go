@@ -0,0 +1,79 @@
+package v1
+
+// The severities of the rules of a policy.
+const (
+	// ErrorSeverity is the severity of rules that must be followed for the
+	// Index to be valid.
+	ErrorSeverity = "error"
+	// WarningSeverity is the severity of rules that are reported but do not
+	// make the Index invalid, unless it is strict.
+	WarningSeverity = "warning"
+	// InfoSeverity is the severity of rules that are only reported for
+	// information.
+	InfoSeverity = "info"
+)
+
+// The targets of the rules of a policy.
+const (
+	ResourceTarget   = "resource"
+	RepositoryTarget = "repository"
+	OutputTarget     = "output"
+)
+
+// RuleSpec is a rule of a policy, which every target of the rule must
+// follow.
+type RuleSpec struct {
+	Name string `yaml:"name"`
+	// Target is what the rule is checked against: resource, repository or
+	// output.
+	Target string `yaml:"target"`
+	// Selector restricts the rule to the resources it selects, or to the
+	// outputs of those resources. Unlike the selector of a generator, its
+	// kind may be empty to select resources of every kind, and only its
+	// labels and namespace apply to repositories.
+	Selector *SelectorSpec `yaml:"selector"`
+	// Condition is a template executed once for each target, with the
+	// context of a PolicyContext. The rule is followed if it outputs
+	// `true`.
+	Condition string `yaml:"condition"`
+	// Message describes why the rule is not followed. It is a template
+	// executed with the same context as the condition.
+	Message string `yaml:"message"`
+	// Severity is the severity of the rule, error if it is empty.
+	Severity string `yaml:"severity"`
+}
+
+// ValidRuleSpecFields is the list of valid fields in a RuleSpec.
+var ValidRuleSpecFields = []string{"name", "target", "selector", "condition", "message", "severity"}
+
+// PolicySpec is the specification of a policy, a set of rules the
+// resources, repositories and outputs of the Index must follow.
+type PolicySpec struct {
+	Name        string            `yaml:"name"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	Rules       []RuleSpec        `yaml:"rules"`
+}
+
+// ValidPolicySpecFields is the list of valid fields in a PolicySpec.
+var ValidPolicySpecFields = []string{"name", "labels", "annotations", "rules"}
+
+// Policy represents a Tpology policy
+type Policy struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Policy     PolicySpec `yaml:"policy"`
+}
+
+// ValidPolicyFields is the list of valid fields in a Policy.
+var ValidPolicyFields = []string{"apiVersion", "policy"}
+
+// PolicyContext is the context the conditions and messages of rules are
+// executed with. Self is the resource spec of a resource target, the
+// repository spec of a repository target, and the spec of the resource or
+// aggregate of an output target.
+type PolicyContext struct {
+	DefaultContext `yaml:",inline"`
+	// Output is the output spec of an output target, with its repository
+	// and file rendered.
+	Output map[string]interface{} `yaml:"output"`
+}
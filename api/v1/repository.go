@@ -2,7 +2,11 @@ package v1
 
 // RepositorySpec is the specification of a repository.
 type RepositorySpec struct {
-	Name        string            `yaml:"name"`
+	Name string `yaml:"name"`
+	// Namespace scopes the name of the repository. Outputs of resources in
+	// the namespace look the repository up in it before the default
+	// namespace.
+	Namespace   string            `yaml:"namespace"`
 	Repository  string            `yaml:"repository"`
 	Branch      string            `yaml:"branch"`
 	Labels      map[string]string `yaml:"labels"`
@@ -10,7 +14,7 @@ type RepositorySpec struct {
 }
 
 // ValidRepositorySpecFields is the list of valid fields in a RepositorySpec.
-var ValidRepositorySpecFields = []string{"name", "repository", "branch", "labels", "annotations"}
+var ValidRepositorySpecFields = []string{"name", "namespace", "repository", "branch", "labels", "annotations"}
 
 // Repository represents a Tpology repository
 type Repository struct {
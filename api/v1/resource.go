@@ -4,8 +4,11 @@ type OutputSpec struct {
 	// Name is the name of the output artifact being generated.
 	Name string `yaml:"name"`
 	// Repository is the name of the repository the output artifact will be committed to.
+	// It may be a template, rendered with the same context as the content.
 	Repository string `yaml:"repository"`
-	// File is the full path to the output artifact in the repository.
+	// File is the full path to the output artifact in the repository. It may
+	// be a template, rendered with the same context as the content, and must
+	// be a clean relative path once rendered.
 	File string `yaml:"file"`
 	// Template is the name of the template that produces the output artifact.
 	Template string `yaml:"template"`
@@ -19,18 +22,67 @@ type OutputSpec struct {
 // ValidOutputSpecFields is the list of valid fields in a OutputSpec.
 var ValidOutputSpecFields = []string{"name", "repository", "file", "template", "context", "postProcessor"}
 
+// RefKey is the key of a map in the data of a resource that refers to
+// another resource.
+const RefKey = "$ref"
+
+// ReferenceSpec refers to a resource by kind and name. A reference with no
+// namespace is relative to the namespace of the document it is in.
+type ReferenceSpec struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// ValidReferenceSpecFields is the list of valid fields in a ReferenceSpec.
+var ValidReferenceSpecFields = []string{"kind", "name", "namespace"}
+
+// SecretKey is the key of a map in the data of a resource that refers to a
+// secret, which is not stored in the model.
+const SecretKey = "$secret"
+
+// SecretSpec refers to a secret by the name of the provider it is resolved
+// by and its key in that provider, such as `db/password`.
+type SecretSpec struct {
+	Provider string `yaml:"provider"`
+	Key      string `yaml:"key"`
+}
+
+// ValidSecretSpecFields is the list of valid fields in a SecretSpec.
+var ValidSecretSpecFields = []string{"provider", "key"}
+
 // ResourceSpec is the specification of a resource.
 type ResourceSpec struct {
-	Name        string            `yaml:"name"`
-	Kind        string            `yaml:"kind"`
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+	// Namespace scopes the name of the resource, which only has to be
+	// unique among the resources of the same kind in the namespace.
+	Namespace   string            `yaml:"namespace"`
 	Labels      map[string]string `yaml:"labels"`
 	Annotations map[string]string `yaml:"annotations"`
-	Data        interface{}       `yaml:"data"`
-	Outputs     []OutputSpec      `yaml:"outputs"`
+	// Data is the free-form data of the resource. A map whose only key is
+	// `$ref`, such as `{$ref: {kind: database, name: orders}}`, refers to
+	// another resource in the same way as a ReferenceSpec. References must
+	// resolve, and are replaced by the spec of the resource they refer to
+	// when the resource is rendered. A map whose only key is `$secret`, such
+	// as `{$secret: {provider: file, key: db/password}}`, refers to a secret
+	// in the same way as a SecretSpec, and is replaced by its value when the
+	// resource is rendered.
+	Data    interface{}  `yaml:"data"`
+	Outputs []OutputSpec `yaml:"outputs"`
+	// DependsOn is the list of resources the outputs of this resource depend
+	// on, in addition to those their templates refer to.
+	DependsOn []ReferenceSpec `yaml:"dependsOn"`
+	// Computed are fields of the data whose value is derived from other
+	// data of the Index, keyed by field. Each is a template, such as
+	// `{{ mul .Self.data.shards 3 }}`, executed with the same context as the
+	// templates of outputs once the Index is loaded, and its output replaces
+	// the field of the data, as a number or a boolean if it is one.
+	Computed map[string]string `yaml:"computed"`
 }
 
 // ValidResourceSpecFields is the list of valid fields in a ResourceSpec.
-var ValidResourceSpecFields = []string{"name", "kind", "labels", "annotations", "data", "outputs"}
+var ValidResourceSpecFields = []string{"name", "kind", "namespace", "labels", "annotations", "data", "outputs", "dependsOn", "computed"}
 
 // Resource represents a Tpology resource
 type Resource struct {
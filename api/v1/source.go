@@ -0,0 +1,24 @@
+package v1
+
+import "fmt"
+
+// SourceSpec is where a document was loaded from.
+type SourceSpec struct {
+	// File is the path of the file the document was loaded from.
+	File string `yaml:"file"`
+	// Document is the index of the document in the file, starting at 0.
+	Document int `yaml:"document"`
+	// StartLine and EndLine are the first and last lines of the document in
+	// the file, starting at 1.
+	StartLine int `yaml:"startLine"`
+	EndLine   int `yaml:"endLine"`
+}
+
+// String returns the file and line range of the source, such as
+// `resources.yaml:12-20`.
+func (s SourceSpec) String() string {
+	if s.StartLine == s.EndLine {
+		return fmt.Sprintf("%s:%d", s.File, s.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", s.File, s.StartLine, s.EndLine)
+}
@@ -3,14 +3,27 @@ package v1
 // TemplateSpec is the specification of a template.
 type TemplateSpec struct {
 	Name string `yaml:"name"`
+	// Namespace scopes the name of the template. Outputs of resources in the
+	// namespace look the template up in it before the default namespace.
+	Namespace string `yaml:"namespace"`
 	// Content is the text of the template.
 	Content string `yaml:"content"`
+	// File is the path to a file holding the text of the template, relative
+	// to the file the template is declared in. It is read into Content when
+	// the template is loaded.
+	File string `yaml:"file"`
+	// Engine is the name of the engine that renders the template. It
+	// defaults to text, for Go text/template.
+	Engine string `yaml:"engine"`
+	// Parent is the name of a layout template. If set, the parent is
+	// rendered instead, with the blocks this template defines filled in.
+	Parent string `yaml:"parent"`
 	// Functions is a list of function libraries to make available to the template.
 	Functions []string `yaml:"functions"`
 }
 
 // ValidTemplateSpecFields is the list of valid fields in a TemplateSpec.
-var ValidTemplateSpecFields = []string{"name", "content"}
+var ValidTemplateSpecFields = []string{"name", "namespace", "content", "file", "engine", "parent"}
 
 // Template represents a Tpology template
 type Template struct {
@@ -0,0 +1,15 @@
+package v2
+
+// AggregateSpec is the specification of an aggregate, which renders each of
+// its outputs once with the resources matching any of its selectors.
+type AggregateSpec struct {
+	Selectors []SelectorSpec `yaml:"selectors"`
+	Outputs   []OutputSpec   `yaml:"outputs,omitempty"`
+}
+
+// Aggregate represents a Tpology aggregate
+type Aggregate struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta    `yaml:"metadata"`
+	Spec     AggregateSpec `yaml:"spec"`
+}
@@ -0,0 +1,15 @@
+package v2
+
+// GeneratorSpec is the specification of a generator, which generates its
+// outputs once for every resource matching its selector.
+type GeneratorSpec struct {
+	Selector SelectorSpec `yaml:"selector"`
+	Outputs  []OutputSpec `yaml:"outputs,omitempty"`
+}
+
+// Generator represents a Tpology generator
+type Generator struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta    `yaml:"metadata"`
+	Spec     GeneratorSpec `yaml:"spec"`
+}
@@ -0,0 +1,34 @@
+// Package v2 is the second version of the Tpology API. A v2 document names
+// its type in kind and separates the metadata of the document from its spec.
+package v2
+
+// APIVersion is the apiVersion of v2 documents.
+const APIVersion = "v2"
+
+// ObjectMeta is the metadata of a document, which identifies and labels it.
+type ObjectMeta struct {
+	Name string `yaml:"name"`
+	// Kind is the kind of a resource. It is only set on resources.
+	Kind string `yaml:"kind,omitempty"`
+	// Namespace scopes the name of a resource, template or repository.
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// TypeMeta is the header of every v2 document.
+type TypeMeta struct {
+	APIVersion string `yaml:"apiVersion"`
+	// Kind is the type of the document, such as Resource or Template.
+	Kind string `yaml:"kind"`
+}
+
+// The kinds of v2 documents.
+const (
+	ResourceKind   = "Resource"
+	TemplateKind   = "Template"
+	RepositoryKind = "Repository"
+	GeneratorKind  = "Generator"
+	AggregateKind  = "Aggregate"
+	PolicyKind     = "Policy"
+)
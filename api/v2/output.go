@@ -0,0 +1,51 @@
+package v2
+
+// FileOutputType is the type of outputs rendered to a file in a repository.
+const FileOutputType = "file"
+
+// OutputSpec is an output artifact of a resource, generator or aggregate.
+// The Type of the output selects which of its targets is set.
+type OutputSpec struct {
+	Name string `yaml:"name"`
+	// Type is the type of the output. It defaults to file.
+	Type string `yaml:"type,omitempty"`
+	// Template is the name of the template that produces the output artifact.
+	Template string `yaml:"template"`
+	// Context specifies the context used to render the template.
+	Context string `yaml:"context,omitempty"`
+	// PostProcessor is the name of a post-processor to run on the template
+	// output to produce the output artifact.
+	PostProcessor string `yaml:"postProcessor,omitempty"`
+	// File is the target of a file output.
+	File *FileOutputSpec `yaml:"file,omitempty"`
+}
+
+// FileOutputSpec is the target of a file output.
+type FileOutputSpec struct {
+	// Repository is the name of the repository the output artifact will be
+	// committed to. It may be a template, rendered with the same context as
+	// the content.
+	Repository string `yaml:"repository"`
+	// Path is the full path to the output artifact in the repository. It may
+	// be a template, rendered with the same context as the content, and must
+	// be a clean relative path once rendered.
+	Path string `yaml:"path"`
+}
+
+// ReferenceSpec refers to a resource by kind and name, in the namespace of
+// the document it is in unless it has one.
+type ReferenceSpec struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// SelectorSpec selects resources by kind and labels.
+type SelectorSpec struct {
+	// Kind is the kind of the selected resources.
+	Kind string `yaml:"kind"`
+	// Labels are the labels the selected resources must all have.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Namespace is the namespace of the selected resources, if any.
+	Namespace string `yaml:"namespace,omitempty"`
+}
@@ -0,0 +1,25 @@
+package v2
+
+// RuleSpec is a rule of a policy, which every target of the rule must
+// follow.
+type RuleSpec struct {
+	Name      string        `yaml:"name"`
+	Target    string        `yaml:"target"`
+	Selector  *SelectorSpec `yaml:"selector,omitempty"`
+	Condition string        `yaml:"condition"`
+	Message   string        `yaml:"message,omitempty"`
+	Severity  string        `yaml:"severity,omitempty"`
+}
+
+// PolicySpec is the specification of a policy, a set of rules the
+// resources, repositories and outputs of the Index must follow.
+type PolicySpec struct {
+	Rules []RuleSpec `yaml:"rules"`
+}
+
+// Policy represents a Tpology policy
+type Policy struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta `yaml:"metadata"`
+	Spec     PolicySpec `yaml:"spec"`
+}
@@ -0,0 +1,15 @@
+package v2
+
+// RepositorySpec is the specification of a repository.
+type RepositorySpec struct {
+	// URL is the location of the repository.
+	URL    string `yaml:"url"`
+	Branch string `yaml:"branch"`
+}
+
+// Repository represents a Tpology repository
+type Repository struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta     `yaml:"metadata"`
+	Spec     RepositorySpec `yaml:"spec"`
+}
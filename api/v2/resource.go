@@ -0,0 +1,21 @@
+package v2
+
+// ResourceSpec is the specification of a resource.
+type ResourceSpec struct {
+	Data    interface{}  `yaml:"data,omitempty"`
+	Outputs []OutputSpec `yaml:"outputs,omitempty"`
+	// DependsOn is the list of resources the outputs of this resource depend
+	// on, in addition to those their templates refer to.
+	DependsOn []ReferenceSpec `yaml:"dependsOn,omitempty"`
+	// Computed are fields of the data whose value is derived from other
+	// data of the Index, keyed by field.
+	Computed map[string]string `yaml:"computed,omitempty"`
+}
+
+// Resource represents a Tpology resource. Its kind and name are in its
+// metadata.
+type Resource struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta   `yaml:"metadata"`
+	Spec     ResourceSpec `yaml:"spec"`
+}
@@ -0,0 +1,24 @@
+package v2
+
+// TemplateSpec is the specification of a template.
+type TemplateSpec struct {
+	// Content is the text of the template.
+	Content string `yaml:"content,omitempty"`
+	// File is the path to a file holding the text of the template, relative
+	// to the file the template is declared in.
+	File string `yaml:"file,omitempty"`
+	// Engine is the name of the engine that renders the template.
+	Engine string `yaml:"engine,omitempty"`
+	// Parent is the name of a layout template.
+	Parent string `yaml:"parent,omitempty"`
+	// Functions is a list of function libraries to make available to the
+	// template.
+	Functions []string `yaml:"functions,omitempty"`
+}
+
+// Template represents a Tpology template
+type Template struct {
+	TypeMeta `yaml:",inline"`
+	Metadata ObjectMeta   `yaml:"metadata"`
+	Spec     TemplateSpec `yaml:"spec"`
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Export returns the documents of the Index as a Bundle. Defaults are
+// applied, such as the engine of templates, and the content of templates
+// loaded from a file is inlined, the file being recorded in the sources of
+// the template. Values decrypted in the data of resources are exported
+// encrypted, and the computed values of resources derived from decrypted
+// values are left out of it, Import computing them again. Other computed
+// values are exported as they were computed.
+func (i *Index) Export() *v1.Bundle {
+	b := &v1.Bundle{
+		APIVersion:   "v1",
+		Resources:    []v1.ResourceSpec{},
+		Templates:    []v1.TemplateSpec{},
+		Repositories: []v1.RepositorySpec{},
+		Generators:   []v1.GeneratorSpec{},
+		Aggregates:   []v1.AggregateSpec{},
+		Policies:     []v1.PolicySpec{},
+		Sources:      map[string][]v1.SourceSpec{},
+	}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			b.Resources = append(b.Resources, i.exportedResource(i.resourceByKind[kind][name]))
+		}
+	}
+	for _, name := range sortedTemplateNames(i.template) {
+		t := i.template[name].Template
+		if t.Engine == "" {
+			t.Engine = DefaultEngine
+		}
+		t.File = ""
+		b.Templates = append(b.Templates, t)
+	}
+	for _, name := range sortedRepositoryNames(i.repository) {
+		b.Repositories = append(b.Repositories, i.repository[name].Repository)
+	}
+	for _, name := range sortedGeneratorNames(i.generator) {
+		b.Generators = append(b.Generators, i.generator[name].Generator)
+	}
+	for _, name := range sortedAggregateNames(i.aggregate) {
+		b.Aggregates = append(b.Aggregates, i.aggregate[name].Aggregate)
+	}
+	for _, name := range sortedPolicyNames(i.policy) {
+		b.Policies = append(b.Policies, i.policy[name].Policy)
+	}
+	for id, sources := range i.sources {
+		b.Sources[id] = append([]v1.SourceSpec{}, sources...)
+	}
+	return b
+}
+
+// exportedResource returns the spec of the resource as it is exported, with
+// the values decrypted in its data encrypted, and without its computed
+// values if they are derived from decrypted values.
+func (i *Index) exportedResource(r *v1.Resource) v1.ResourceSpec {
+	spec := r.Resource
+	if ciphertexts := i.encrypted[r]; len(ciphertexts) > 0 {
+		spec.Data = encryptedData(spec.Data, "data", ciphertexts)
+	}
+	if i.derived[r] {
+		spec.Data = withoutComputed(spec.Data, spec.Computed)
+	}
+	return spec
+}
+
+// withoutComputed returns a copy of the data of a resource without the fields
+// that are computed, or nil if no other field is left.
+func withoutComputed(data interface{}, computed map[string]string) interface{} {
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			if _, ok := computed[fmt.Sprint(k)]; !ok {
+				m[k] = e
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if _, ok := computed[k]; !ok {
+				m[k] = e
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	}
+	return data
+}
+
+// Import adds the documents of a Bundle to the Index, together with the
+// files they were loaded from, and then validates it, returning the
+// diagnostics of both.
+func (i *Index) Import(b *v1.Bundle) Diagnostics {
+	if b.APIVersion != "v1" {
+		return errorDiagnostics([]error{fmt.Errorf("invalid apiVersion")})
+	}
+	errs := []error{}
+	for _, spec := range b.Resources {
+		errs = appendError(errs, i.AddResource(&v1.Resource{APIVersion: b.APIVersion, Resource: spec}))
+	}
+	for _, spec := range b.Templates {
+		errs = appendError(errs, i.AddTemplate(&v1.Template{APIVersion: b.APIVersion, Template: spec}))
+	}
+	for _, spec := range b.Repositories {
+		errs = appendError(errs, i.AddRepository(&v1.Repository{APIVersion: b.APIVersion, Repository: spec}))
+	}
+	for _, spec := range b.Generators {
+		errs = appendError(errs, i.AddGenerator(&v1.Generator{APIVersion: b.APIVersion, Generator: spec}))
+	}
+	for _, spec := range b.Aggregates {
+		errs = appendError(errs, i.AddAggregate(&v1.Aggregate{APIVersion: b.APIVersion, Aggregate: spec}))
+	}
+	for _, spec := range b.Policies {
+		errs = appendError(errs, i.AddPolicy(&v1.Policy{APIVersion: b.APIVersion, Policy: spec}))
+	}
+	if len(errs) > 0 {
+		return errorDiagnostics(errs)
+	}
+	for id, sources := range b.Sources {
+		for _, source := range sources {
+			i.addSource(id, source)
+		}
+	}
+	return i.Validate()
+}
+
+// appendError appends err to errs if it is not nil.
+func appendError(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+// ReadBundle reads a Bundle in YAML or JSON. Fields that are not part of a
+// Bundle are errors.
+func ReadBundle(r io.Reader) (*v1.Bundle, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b := &v1.Bundle{}
+	if err := yaml.UnmarshalStrict(content, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteBundle writes a Bundle in format, which is either yaml or json. The
+// fields of JSON objects are named as in YAML, and both are sorted.
+func WriteBundle(w io.Writer, b *v1.Bundle, format string) error {
+	content, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "yaml":
+	case "json":
+		var doc interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return err
+		}
+		if content, err = json.MarshalIndent(jsonValue(doc), "", "  "); err != nil {
+			return err
+		}
+		content = append(content, '\n')
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// jsonValue replaces the maps unmarshalled from YAML in v, which are keyed by
+// interface{}, with maps keyed by string, so that v can be marshalled to
+// JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for n, e := range v {
+			v[n] = jsonValue(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// Test_Index_Export tests the Export and Import functions of the Index. It
+// expects a bundle written in either format to be read back and imported
+// into an Index that exports the same bundle and renders the same artifacts.
+func Test_Index_Export(t *testing.T) {
+	i := mustLoad(t, "testdata/043-graph")
+	for _, format := range []string{"json", "yaml"} {
+		exported := bytes.Buffer{}
+		if err := WriteBundle(&exported, i.Export(), format); err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		b, err := ReadBundle(bytes.NewReader(exported.Bytes()))
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		imported := NewIndex()
+		if errs := imported.Import(b); len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		reexported := bytes.Buffer{}
+		if err := WriteBundle(&reexported, imported.Export(), format); err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		if exported.String() != reexported.String() {
+			t.Errorf("Expected %s, got %s", exported.String(), reexported.String())
+		}
+		artifacts, errs := NewRenderer(imported).Render(context.Background())
+		if len(errs) != 0 || len(artifacts) != 1 || string(artifacts[0].Content) != "database: 5432" {
+			t.Errorf("Expected database: 5432, got %v %v", artifacts, errs)
+		}
+		impact, _ := imported.Affected([]string{"testdata/043-graph/database-1.yaml"})
+		if len(impact.Outputs) != 1 {
+			t.Errorf("Expected 1 output affected, got %d", len(impact.Outputs))
+		}
+	}
+}
+
+// Test_Index_Export_Computed tests the Export function of the Index. It
+// expects computed values that are not derived from decrypted values to be
+// exported, and imported again.
+func Test_Index_Export_Computed(t *testing.T) {
+	b := mustLoad(t, "testdata/056-computed").Export()
+	if errs := NewIndex().Import(b); len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	for _, r := range b.Resources {
+		if r.Kind != "service" {
+			continue
+		}
+		if url := r.Data.(map[interface{}]interface{})["url"]; url != "postgres://orders.db:5432" {
+			t.Errorf("Expected postgres://orders.db:5432, got %v", url)
+		}
+		return
+	}
+	t.Errorf("Expected the service to be exported, got %v", b.Resources)
+}
+
+// Test_Index_Export_Sorted tests the Export function of the Index. It
+// expects resources sorted by kind and name, and the engine of templates
+// defaulted.
+func Test_Index_Export_Sorted(t *testing.T) {
+	b := mustLoad(t, "testdata/043-graph").Export()
+	kinds := []string{}
+	for _, r := range b.Resources {
+		kinds = append(kinds, r.Kind)
+	}
+	if strings.Join(kinds, ",") != "database,queue,service" {
+		t.Errorf("Expected database,queue,service, got %s", strings.Join(kinds, ","))
+	}
+	if b.Templates[0].Engine != DefaultEngine {
+		t.Errorf("Expected %s, got %s", DefaultEngine, b.Templates[0].Engine)
+	}
+	if len(b.Sources["template:service"]) != 1 || b.Sources["template:service"][0].String() != "testdata/043-graph/template-1.yaml:1-4" {
+		t.Errorf("Expected testdata/043-graph/template-1.yaml:1-4, got %v", b.Sources["template:service"])
+	}
+}
+
+// Test_ReadBundle_InvalidField tests the ReadBundle function. It expects an
+// error for a field that is not part of a bundle.
+func Test_ReadBundle_InvalidField(t *testing.T) {
+	_, err := ReadBundle(strings.NewReader(`{"apiVersion": "v1", "resources": [{"name": "a", "kind": "b", "invalid": 1}]}`))
+	if err == nil || !strings.Contains(err.Error(), "field invalid not found") {
+		t.Errorf("Expected field invalid not found error, got %v", err)
+	}
+}
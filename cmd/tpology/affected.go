@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/tpology/core"
+)
+
+// affectedCommand prints the resources, outputs and repositories affected by
+// a change to some files of the model.
+func affectedCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("affected", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "output format, text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(stderr, "usage: tpology affected [flags] <dir> <file>...")
+		return 2
+	}
+	i := load(flags.Arg(0), stderr)
+	if i == nil {
+		return 1
+	}
+	impact, errs := i.Affected(flags.Args()[1:])
+	switch *format {
+	case "text":
+		for _, f := range impact.Unknown {
+			fmt.Fprintf(stdout, "unknown %s\n", f)
+		}
+		for _, nodes := range [][]*core.GraphNode{impact.Resources, impact.Templates, impact.Generators, impact.Aggregates, impact.Outputs, impact.Repositories} {
+			for _, n := range nodes {
+				fmt.Fprintf(stdout, "%s\n", n.ID)
+			}
+		}
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(impact); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(stderr, "tpology affected: unknown format %s\n", *format)
+		return 2
+	}
+	if len(errs) > 0 {
+		printErrors(stderr, errs)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// checkCommand prints the diagnostics of the model, such as the violations
+// of its policies, and fails if any is an error.
+func checkCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "output format, text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	_, diags := loadDiagnostics(dirArg(flags.Args()), true)
+	switch *format {
+	case "text":
+		printDiagnostics(stdout, diags)
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(stderr, "tpology check: unknown format %s\n", *format)
+		return 2
+	}
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/tpology/core"
+)
+
+// encryptCommand encrypts the values of the data of the resources of the
+// model whose field matches --match, in place, with the key of --key-file.
+func encryptCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	match := flags.String("match", "", "regular expression matching the fields to encrypt")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *match == "" {
+		fmt.Fprintln(stderr, "tpology encrypt: --match is required")
+		return 2
+	}
+	re, err := regexp.Compile(*match)
+	if err != nil {
+		fmt.Fprintf(stderr, "tpology encrypt: invalid --match: %s\n", err)
+		return 2
+	}
+	return rewriteFiles("encrypt", dirArg(flags.Args()), stdout, stderr, func(path string, content []byte, key []byte) ([]byte, bool, error) {
+		return core.EncryptValues(path, content, key, re)
+	})
+}
+
+// decryptCommand decrypts the encrypted values of the data of the resources
+// of the model, in place, with the key of --key-file.
+func decryptCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	return rewriteFiles("decrypt", dirArg(flags.Args()), stdout, stderr, core.DecryptValues)
+}
+
+// rewriteFiles rewrites every file under dir with rewrite and the key of
+// --key-file, printing the files it changed.
+func rewriteFiles(name string, dir string, stdout io.Writer, stderr io.Writer, rewrite func(path string, content []byte, key []byte) ([]byte, bool, error)) int {
+	if keyFile == "" {
+		fmt.Fprintf(stderr, "tpology %s: --key-file is required\n", name)
+		return 2
+	}
+	key, err := core.ReadSecretKey(keyFile)
+	if err != nil {
+		printErrors(stderr, []error{err})
+		return 1
+	}
+	errs := []error{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		rewritten, changed, err := rewrite(path, content, key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+			return nil
+		}
+		if !changed {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if err := ioutil.WriteFile(path, rewritten, info.Mode().Perm()); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		fmt.Fprintf(stdout, "%sed %s\n", name, path)
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		printErrors(stderr, errs)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/tpology/core"
+)
+
+// exportCommand prints the model as a single bundle.
+func exportCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "json", "output format, json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "json" && *format != "yaml" {
+		fmt.Fprintf(stderr, "tpology export: unknown format %s\n", *format)
+		return 2
+	}
+	i := load(dirArg(flags.Args()), stderr)
+	if i == nil {
+		return 1
+	}
+	if err := core.WriteBundle(stdout, i.Export(), *format); err != nil {
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
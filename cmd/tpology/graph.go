@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// graphCommand prints the dependency graph of the model.
+func graphCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "dot", "output format, dot or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	i := load(dirArg(flags.Args()), stderr)
+	if i == nil {
+		return 1
+	}
+	g, errs := i.Graph()
+	switch *format {
+	case "dot":
+		if err := g.WriteDOT(stdout); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(g); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(stderr, "tpology graph: unknown format %s\n", *format)
+		return 2
+	}
+	if len(errs) > 0 {
+		printErrors(stderr, errs)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// lintCommand prints the warnings of the lint pass over the model, followed
+// by those of the check of the fields templates read, and fails if any is an
+// error, as they are with --strict.
+func lintCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "output format, text or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	i := load(dirArg(flags.Args()), stderr)
+	if i == nil {
+		return 1
+	}
+	diags := append(i.Lint(), i.CheckTemplates()...)
+	if strict {
+		diags = diags.Strict()
+	}
+	switch *format {
+	case "text":
+		printDiagnostics(stdout, diags)
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(stderr, "tpology lint: unknown format %s\n", *format)
+		return 2
+	}
+	if diags.HasErrors() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+// Command tpology loads a Tpology model from a directory and operates on it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tpology/core"
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// command runs a subcommand with its arguments, returning the exit code.
+type command func(args []string, stdout io.Writer, stderr io.Writer) int
+
+// commands is the list of subcommands, keyed by name.
+var commands = map[string]command{
+	"affected": affectedCommand,
+	"check":    checkCommand,
+	"decrypt":  decryptCommand,
+	"encrypt":  encryptCommand,
+	"export":   exportCommand,
+	"graph":    graphCommand,
+	"lint":     lintCommand,
+	"migrate":  migrateCommand,
+	"serve":    serveCommand,
+}
+
+// strict is true if the warnings of the model are promoted to errors, as set
+// by the --strict flag.
+var strict bool
+
+// keyFile is the file holding the key the encrypted values of the model are
+// decrypted with, as set by the --key-file flag.
+var keyFile string
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the subcommand named by the first argument after the flags.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tpology", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&strict, "strict", false, "promote the warnings of the model to errors")
+	flags.StringVar(&keyFile, "key-file", "", "file holding the base64 encoded key of the encrypted values of the model")
+	flags.Usage = func() { usage(stderr) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "tpology: unknown command %s\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	return cmd(args[1:], stdout, stderr)
+}
+
+// usage prints the list of subcommands.
+func usage(w io.Writer) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage: tpology [--strict] [--key-file file] <command> [flags] [dir|bundle]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+}
+
+// load loads the model in dir, or imports it if dir is a bundle file written
+// by the export command, printing its diagnostics to stderr. It returns nil
+// if the model has errors.
+func load(dir string, stderr io.Writer) *core.Index {
+	i, diags := loadDiagnostics(dir, true)
+	printDiagnostics(stderr, diags)
+	if diags.HasErrors() {
+		return nil
+	}
+	return i
+}
+
+// loadDiagnostics loads the model in dir, or imports it if dir is a bundle
+// file, and returns it with its diagnostics. Its encrypted values are
+// decrypted with the key of --key-file if decrypt is true.
+func loadDiagnostics(dir string, decrypt bool) (*core.Index, core.Diagnostics) {
+	i := core.NewIndex()
+	i.Strict = strict
+	if decrypt && keyFile != "" {
+		key, err := core.ReadSecretKey(keyFile)
+		if err != nil {
+			return i, core.Diagnostics{{Severity: v1.ErrorSeverity, Err: err}}
+		}
+		i.DecryptionKey = key
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return i, importBundle(i, dir)
+	}
+	return i, i.Load(dir)
+}
+
+// importBundle imports the bundle in the file at path into the Index.
+func importBundle(i *core.Index, path string) core.Diagnostics {
+	f, err := os.Open(path)
+	if err != nil {
+		return core.Diagnostics{{Severity: v1.ErrorSeverity, Err: err}}
+	}
+	defer f.Close()
+	b, err := core.ReadBundle(f)
+	if err != nil {
+		return core.Diagnostics{{Severity: v1.ErrorSeverity, Err: fmt.Errorf("%s: %s", path, err)}}
+	}
+	return i.Import(b)
+}
+
+// printDiagnostics prints each diagnostic on its own line, prefixed with its
+// severity.
+func printDiagnostics(w io.Writer, diags core.Diagnostics) {
+	for _, d := range diags {
+		fmt.Fprintf(w, "%s: %s\n", d.Severity, d)
+	}
+}
+
+// printErrors prints each error on its own line.
+func printErrors(w io.Writer, errs []error) {
+	for _, err := range errs {
+		fmt.Fprintf(w, "error: %s\n", err)
+	}
+}
+
+// dirArg returns the model directory given in args, or the current
+// directory.
+func dirArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return "."
+}
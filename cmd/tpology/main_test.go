@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tpology/core"
+)
+
+// Test_Run_UnknownCommand tests the run function. It expects usage and exit
+// code 2 for a command that does not exist.
+func Test_Run_UnknownCommand(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"missing"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected 2, got %d", code)
+	}
+	if !bytes.HasPrefix(stderr.Bytes(), []byte("tpology: unknown command missing\n")) {
+		t.Errorf("Expected unknown command error, got %s", stderr.String())
+	}
+}
+
+// Test_Run_Graph tests the graph command. It expects the graph of the model
+// as JSON.
+func Test_Run_Graph(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"graph", "--format", "json", "../../testdata/043-graph"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	g := core.Graph{}
+	if err := json.Unmarshal(stdout.Bytes(), &g); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if len(g.Nodes) != 6 || len(g.Edges) != 5 {
+		t.Errorf("Expected 6 nodes and 5 edges, got %d and %d", len(g.Nodes), len(g.Edges))
+	}
+}
+
+// Test_Run_Affected tests the affected command. It expects the nodes
+// downstream of a changed file, one per line.
+func Test_Run_Affected(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	dir := "../../testdata/043-graph"
+	if code := run([]string{"affected", dir, dir + "/database-1.yaml"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	expected := "resource:database/orders\noutput:service/orders/config\nrepository:repo-1\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout.String())
+	}
+}
+
+// Test_Run_Export tests the export command. It expects a bundle that the
+// other commands can load in place of a directory.
+func Test_Run_Export(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"export", "../../testdata/043-graph"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	bundle := filepath.Join(t.TempDir(), "bundle.json")
+	if err := ioutil.WriteFile(bundle, stdout.Bytes(), 0644); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	stdout.Reset()
+	if code := run([]string{"graph", "--format", "json", bundle}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	g := core.Graph{}
+	if err := json.Unmarshal(stdout.Bytes(), &g); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if len(g.Nodes) != 6 || len(g.Edges) != 5 {
+		t.Errorf("Expected 6 nodes and 5 edges, got %d and %d", len(g.Nodes), len(g.Edges))
+	}
+}
+
+// Test_Run_Migrate tests the migrate command. It expects every v1 file of
+// the model to be rewritten, and the migrated model to load as before.
+func Test_Run_Migrate(t *testing.T) {
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../testdata/043-graph/*")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(f)), content, 0644); err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+	}
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"migrate", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	if n := bytes.Count(stdout.Bytes(), []byte("migrated ")); n != len(files) {
+		t.Errorf("Expected %d files to be migrated, got %s", len(files), stdout.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"migrate", dir}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Errorf("Expected 0 and no output, got %d and %s", code, stdout.String())
+	}
+	if code := run([]string{"graph", "--format", "json", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	g := core.Graph{}
+	if err := json.Unmarshal(stdout.Bytes(), &g); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if len(g.Nodes) != 6 || len(g.Edges) != 5 {
+		t.Errorf("Expected 6 nodes and 5 edges, got %d and %d", len(g.Nodes), len(g.Edges))
+	}
+}
+
+// Test_Run_Check tests the check command. It expects the violations of rules
+// of warning severity, one per line, and exit code 1 for violations of rules
+// of error severity.
+func Test_Run_Check(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"check", "../../testdata/057-policies"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	expected := "warning: policy platform: rule service-team: resource:service/orders: service orders has no team label\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stdout.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"check", "../../testdata/validate/058-policy-violation"}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected 1, got %d", code)
+	}
+}
+
+// Test_Run_Strict tests the --strict flag. It expects the warnings of the
+// model to be errors, failing the commands that load it.
+func Test_Run_Strict(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"--strict", "graph", "../../testdata/057-policies"}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected 1, got %d", code)
+	}
+	expected := "error: policy platform: rule service-team: resource:service/orders: service orders has no team label\n"
+	if stderr.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, stderr.String())
+	}
+	stderr.Reset()
+	if code := run([]string{"graph", "../../testdata/057-policies"}, &stdout, &stderr); code != 0 {
+		t.Errorf("Expected 0, got %d: %s", code, stderr.String())
+	}
+}
+
+// Test_Run_Lint tests the lint command. It expects the warnings of the lint
+// pass and of the check of templates, one per line, and exit code 1 with
+// --strict.
+func Test_Run_Lint(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"lint", "../../testdata/060-lint"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	if n := bytes.Count(stdout.Bytes(), []byte("warning: ")); n != 5 {
+		t.Errorf("Expected 5 warnings, got %s", stdout.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"--strict", "lint", "../../testdata/060-lint"}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected 1, got %d", code)
+	}
+	if n := bytes.Count(stdout.Bytes(), []byte("error: ")); n != 5 {
+		t.Errorf("Expected 5 errors, got %s", stdout.String())
+	}
+}
+
+// Test_Run_EncryptDecrypt tests the encrypt and decrypt commands. It expects
+// the encrypted values of the model to be decrypted in place with the key of
+// --key-file, encrypted again, and to load with the key.
+func Test_Run_EncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../testdata/064-encrypted/*")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(f)), content, 0644); err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+	}
+	key := "../../testdata/064-encrypted.key"
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"decrypt", dir}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected 2 without --key-file, got %d", code)
+	}
+	if code := run([]string{"--key-file", key, "decrypt", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "decrypted "+filepath.Join(dir, "resources.yaml")+"\n" {
+		t.Errorf("Expected resources.yaml to be decrypted, got %s", stdout.String())
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "resources.yaml"))
+	if !bytes.Contains(content, []byte("password: hunter2")) || !bytes.Contains(content, []byte("port: 5432\n")) {
+		t.Errorf("Expected the password to be decrypted, got %s", content)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"--key-file", key, "encrypt", "--match", "^password$", dir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected 0, got %d: %s", code, stderr.String())
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "resources.yaml"))
+	if bytes.Contains(content, []byte("hunter2")) || !bytes.Contains(content, []byte("- abc")) {
+		t.Errorf("Expected only the password to be encrypted, got %s", content)
+	}
+	stdout.Reset()
+	if code := run([]string{"--key-file", key, "check", dir}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Errorf("Expected 0 and no output, got %d and %s", code, stdout.String())
+	}
+	if code := run([]string{"check", dir}, &stdout, &stderr); code != 0 || !bytes.Contains(stdout.Bytes(), []byte("value is encrypted and there is no decryption key")) {
+		t.Errorf("Expected 0 and a warning, got %d and %s", code, stdout.String())
+	}
+}
+
+// Test_ReloadModel tests the reloadModel function of the serve command. It
+// expects the model to be loaded into the Server only when its files change,
+// and a model with errors to leave the previous one served.
+func Test_ReloadModel(t *testing.T) {
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../testdata/061-template-check/*")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(f)), content, 0644); err != nil {
+			t.Fatalf("Expected nil, got %s", err)
+		}
+	}
+	health := func(s *core.Server) string {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		status := struct{ Status string }{}
+		json.Unmarshal(w.Body.Bytes(), &status)
+		return status.Status
+	}
+
+	s := core.NewServer()
+	stderr := bytes.Buffer{}
+	fingerprint := reloadModel(s, dir, "", false, &stderr)
+	if fingerprint == "" || health(s) != "ok" {
+		t.Fatalf("Expected the model to be served, got %s: %s", health(s), stderr.String())
+	}
+	if reloadModel(s, dir, fingerprint, false, &stderr) != fingerprint {
+		t.Errorf("Expected the fingerprint of an unchanged model to be the same")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.yaml"), []byte("apiVersion: v0\n"), 0644); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if reloadModel(s, dir, fingerprint, false, &stderr) == fingerprint {
+		t.Errorf("Expected the fingerprint of a changed model to change")
+	}
+	if health(s) != "stale" || !bytes.Contains(stderr.Bytes(), []byte("error: ")) {
+		t.Errorf("Expected the previous model to be served, got %s: %s", health(s), stderr.String())
+	}
+}
+
+// Test_ReloadModel_Decrypt tests the reloadModel function of the serve
+// command. It expects the encrypted values of the model to be served
+// encrypted, unless it is asked to decrypt them.
+func Test_ReloadModel_Decrypt(t *testing.T) {
+	defer func() { keyFile = "" }()
+	keyFile = "../../testdata/064-encrypted.key"
+	content := func(decrypt bool) string {
+		s := core.NewServer()
+		stderr := bytes.Buffer{}
+		reloadModel(s, "../../testdata/064-encrypted", "", decrypt, &stderr)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/outputs/service/orders/config", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %d, got %d %s: %s", http.StatusOK, w.Code, w.Body.String(), stderr.String())
+		}
+		return w.Body.String()
+	}
+	if c := content(false); !strings.Contains(c, "orders:ENC[AES256_GCM,") {
+		t.Errorf("Expected the password to be served encrypted, got %s", c)
+	}
+	if c := content(true); strings.Contains(c, "ENC[") {
+		t.Errorf("Expected the password to be decrypted, got %s", c)
+	}
+
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	keyFile = ""
+	if code := serveCommand([]string{"--decrypt", "../../testdata/064-encrypted"}, &stdout, &stderr); code != 2 || stderr.String() != "tpology serve: --decrypt requires --key-file\n" {
+		t.Errorf("Expected 2 and an error, got %d %s", code, stderr.String())
+	}
+}
+
+// Test_Serve_SecretProviders tests the secret providers of the serve command.
+// It expects outputs with secrets to be rendered with the providers of
+// --secret-provider, and an error for invalid ones.
+func Test_Serve_SecretProviders(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "db"), 0755); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "db", "password"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	key, err := core.ReadSecretKey("../../testdata/064-encrypted.key")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	encrypted, err := core.EncryptSecret(key, "api-key-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secrets.yaml"), []byte("api/key: "+encrypted+"\n"), 0600); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	t.Setenv("TEST_DB_TOKEN", "token-1")
+	defer func() { keyFile = "" }()
+
+	keyFile = "../../testdata/064-encrypted.key"
+	flags := secretProviderFlags{"file=file:" + dir, "env=env:TEST_", "encrypted=encrypted:" + filepath.Join(dir, "secrets.yaml")}
+	providers, err := flags.providers()
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	s := newServer(providers)
+	i, diags := loadDiagnostics("../../testdata/062-secrets", true)
+	s.SetIndex(i, diags)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/outputs/service/orders/config", nil))
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("orders:[REDACTED]@orders.db [REDACTED] [REDACTED]")) {
+		t.Errorf("Expected the secrets to be resolved and redacted, got %d %s", w.Code, w.Body.String())
+	}
+
+	keyFile = ""
+	for value, expected := range map[string]string{
+		"file":                "invalid --secret-provider file, expected name=type:arg",
+		"file=file:":          "invalid --secret-provider file=file:, expected name=type:arg",
+		"vault=vault:secret/": "unknown secret provider type vault, expected env, file or encrypted",
+		"enc=encrypted:a":     "secret provider enc requires --key-file",
+	} {
+		flags := secretProviderFlags{value}
+		if _, err := flags.providers(); err == nil || err.Error() != expected {
+			t.Errorf("Expected %s for %s, got %v", expected, value, err)
+		}
+	}
+	flags = secretProviderFlags{"env=env:", "env=env:TEST_"}
+	if _, err := flags.providers(); err == nil || err.Error() != "secret provider env is given twice" {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
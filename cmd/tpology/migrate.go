@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/tpology/core"
+)
+
+// migrateCommand rewrites the v1 documents of the model as v2, in place.
+func migrateCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dryRun := flags.Bool("dry-run", false, "print the files that would be migrated without writing them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	errs := []error{}
+	err := filepath.WalkDir(dirArg(flags.Args()), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		migrated, changed, err := core.Migrate(path, content)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+			return nil
+		}
+		if !changed {
+			return nil
+		}
+		if !*dryRun {
+			info, err := d.Info()
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if err := ioutil.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+		}
+		fmt.Fprintf(stdout, "migrated %s\n", path)
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		printErrors(stderr, errs)
+		return 1
+	}
+	return 0
+}
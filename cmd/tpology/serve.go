@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tpology/core"
+)
+
+// serveCommand serves a read-only JSON API over the model on --addr,
+// reloading it when its files change. A model with errors is not served
+// until it is fixed, the previous one being served meanwhile. The secrets in
+// the data of resources are resolved by the providers of --secret-provider,
+// and outputs with secrets of other providers fail to render. The encrypted
+// values of the model are served encrypted unless --decrypt is given, as the
+// outputs rendered from them are not redacted.
+func serveCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	interval := flags.Duration("interval", 2*time.Second, "interval at which the model is checked for changes")
+	decrypt := flags.Bool("decrypt", false, "decrypt the encrypted values of the model with the key of --key-file, serving them in the outputs rendered from them")
+	providers := secretProviderFlags{}
+	flags.Var(&providers, "secret-provider", "secret provider as name=env:PREFIX, name=file:DIR or name=encrypted:FILE, decrypted with the key of --key-file; may be repeated")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(stderr, "tpology serve: --interval must be positive")
+		return 2
+	}
+	if *decrypt && keyFile == "" {
+		fmt.Fprintln(stderr, "tpology serve: --decrypt requires --key-file")
+		return 2
+	}
+	secretProviders, err := providers.providers()
+	if err != nil {
+		fmt.Fprintf(stderr, "tpology serve: %s\n", err)
+		return 2
+	}
+	dir := dirArg(flags.Args())
+	s := newServer(secretProviders)
+	fingerprint := reloadModel(s, dir, "", *decrypt, stderr)
+	go func() {
+		for range time.Tick(*interval) {
+			fingerprint = reloadModel(s, dir, fingerprint, *decrypt, stderr)
+		}
+	}()
+	fmt.Fprintf(stdout, "serving %s on %s\n", dir, *addr)
+	if err := http.ListenAndServe(*addr, s); err != nil {
+		printErrors(stderr, []error{err})
+		return 1
+	}
+	return 0
+}
+
+// newServer returns a Server rendering outputs with the secret providers.
+func newServer(providers map[string]core.SecretProvider) *core.Server {
+	s := core.NewServer()
+	s.Renderer = func(i *core.Index) *core.Renderer {
+		r := core.NewRenderer(i)
+		for name, p := range providers {
+			r.SecretProviders[name] = p
+		}
+		return r
+	}
+	return s
+}
+
+// reloadModel loads the model in dir into the Server if its fingerprint is
+// not fingerprint, decrypting its encrypted values if decrypt is true,
+// printing its diagnostics to stderr, and returns its fingerprint.
+func reloadModel(s *core.Server, dir string, fingerprint string, decrypt bool, stderr io.Writer) string {
+	current, err := modelFingerprint(dir)
+	if err != nil {
+		printErrors(stderr, []error{err})
+		return fingerprint
+	}
+	if current == fingerprint {
+		return fingerprint
+	}
+	i, diags := loadDiagnostics(dir, decrypt)
+	printDiagnostics(stderr, diags)
+	s.SetIndex(i, diags)
+	return current
+}
+
+// modelFingerprint returns the path, size and modification time of every
+// file under dir, or of dir if it is a bundle file, which change when the
+// model is edited.
+func modelFingerprint(dir string) (string, error) {
+	b := strings.Builder{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+// secretProviderFlags are the secret providers given with --secret-provider,
+// as `name=type:arg`.
+type secretProviderFlags []string
+
+// String implements flag.Value
+func (f *secretProviderFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value
+func (f *secretProviderFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// providers returns the secret providers keyed by name. Encrypted files are
+// decrypted with the key of --key-file.
+func (f *secretProviderFlags) providers() (map[string]core.SecretProvider, error) {
+	providers := map[string]core.SecretProvider{}
+	for _, value := range *f {
+		kv := strings.SplitN(value, "=", 2)
+		spec := []string{}
+		if len(kv) == 2 {
+			spec = strings.SplitN(kv[1], ":", 2)
+		}
+		if len(kv) != 2 || kv[0] == "" || len(spec) != 2 || (spec[1] == "" && spec[0] != "env") {
+			return nil, fmt.Errorf("invalid --secret-provider %s, expected name=type:arg", value)
+		}
+		if _, ok := providers[kv[0]]; ok {
+			return nil, fmt.Errorf("secret provider %s is given twice", kv[0])
+		}
+		switch spec[0] {
+		case "env":
+			providers[kv[0]] = core.EnvSecretProvider{Prefix: spec[1]}
+		case "file":
+			providers[kv[0]] = core.FileSecretProvider{Dir: spec[1]}
+		case "encrypted":
+			if keyFile == "" {
+				return nil, fmt.Errorf("secret provider %s requires --key-file", kv[0])
+			}
+			key, err := core.ReadSecretKey(keyFile)
+			if err != nil {
+				return nil, err
+			}
+			providers[kv[0]] = core.EncryptedFileSecretProvider{Path: spec[1], Key: key}
+		default:
+			return nil, fmt.Errorf("unknown secret provider type %s, expected env, file or encrypted", spec[0])
+		}
+	}
+	return providers, nil
+}
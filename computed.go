@@ -0,0 +1,361 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// computeFuncs are the functions computed fields are executed with, in
+// addition to those of text/template.
+var computeFuncs = template.FuncMap{
+	"add": arithmetic(
+		func(a, b int64) (int64, error) { return a + b, nil },
+		func(a, b float64) (float64, error) { return a + b, nil },
+	),
+	"sub": arithmetic(
+		func(a, b int64) (int64, error) { return a - b, nil },
+		func(a, b float64) (float64, error) { return a - b, nil },
+	),
+	"mul": arithmetic(
+		func(a, b int64) (int64, error) { return a * b, nil },
+		func(a, b float64) (float64, error) { return a * b, nil },
+	),
+	"div": arithmetic(
+		func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		},
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		},
+	),
+	"mod": arithmetic(
+		func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a % b, nil
+		},
+		nil,
+	),
+}
+
+// arithmetic returns a template function applying an operation to two
+// numbers, with ints if both are integers and with floats otherwise. The
+// operation is only defined on integers if floats is nil.
+func arithmetic(ints func(a, b int64) (int64, error), floats func(a, b float64) (float64, error)) func(a, b interface{}) (interface{}, error) {
+	return func(a, b interface{}) (interface{}, error) {
+		x, xInt, err := number(a)
+		if err != nil {
+			return nil, err
+		}
+		y, yInt, err := number(b)
+		if err != nil {
+			return nil, err
+		}
+		if xInt && yInt {
+			return ints(int64(x), int64(y))
+		}
+		if floats == nil {
+			return nil, fmt.Errorf("%v and %v are not both integers", a, b)
+		}
+		return floats(x, y)
+	}
+}
+
+// number returns v as a float, and whether it is an integer.
+func number(v interface{}) (float64, bool, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true, nil
+	case int64:
+		return float64(n), true, nil
+	case uint64:
+		return float64(n), true, nil
+	case float64:
+		return n, false, nil
+	}
+	return 0, false, fmt.Errorf("%v is not a number", v)
+}
+
+// computedField is a computed field of a resource of the Index.
+type computedField struct {
+	resource *v1.Resource
+	field    string
+	tmpl     *template.Template
+}
+
+// id returns the ID of the field in errors, such as
+// `resource:service/orders.computed.replicas`.
+func (f *computedField) id() string {
+	return resourceID(f.resource.Resource.Kind, qualifiedName(f.resource.Resource.Namespace, f.resource.Resource.Name)) + ".computed." + f.field
+}
+
+// error returns err prefixed with the resource and the field.
+func (f *computedField) error(err error) error {
+	spec := &f.resource.Resource
+	return fmt.Errorf("resource %s of kind %s: computed field %s: %s", qualifiedName(spec.Namespace, spec.Name), spec.Kind, f.field, err)
+}
+
+// Compute executes the computed fields of the resources of the Index and
+// sets their output in the data of the resources, in place. A field is
+// executed after the computed fields it refers to, of its own resource or of
+// others, and fields that refer to each other are an error, as are missing
+// keys, rather than computing "<no value>". Load and Import compute the
+// fields once the Index is valid, so Compute only has to be called again
+// when resources are added or replaced.
+func (i *Index) Compute() []error {
+	i.derived = map[*v1.Resource]bool{}
+	errs := []error{}
+	fields := []*computedField{}
+	// byResource are the computed fields of each resource
+	byResource := map[*v1.Resource][]*computedField{}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			r := i.resourceByKind[kind][name]
+			names := make([]string, 0, len(r.Resource.Computed))
+			for field := range r.Resource.Computed {
+				names = append(names, field)
+			}
+			sort.Strings(names)
+			for _, field := range names {
+				f := &computedField{resource: r, field: field}
+				t, err := template.New(f.id()).Funcs(computeFuncs).Option("missingkey=error").Parse(r.Resource.Computed[field])
+				if err != nil {
+					errs = append(errs, f.error(err))
+					continue
+				}
+				f.tmpl = t
+				fields = append(fields, f)
+				byResource[r] = append(byResource[r], f)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	order, errs := i.computeOrder(fields, byResource)
+	if len(errs) > 0 {
+		return errs
+	}
+	base := i.defaultContext()
+	copied := map[*v1.Resource]bool{}
+	for _, f := range order {
+		self, err := i.expandedSpecMap(f.resource)
+		if err != nil {
+			errs = append(errs, f.error(err))
+			continue
+		}
+		data := *base
+		data.Self = self
+		data.Namespace = f.resource.Resource.Namespace
+		buf := bytes.Buffer{}
+		if err := f.tmpl.Execute(&buf, &data); err != nil {
+			errs = append(errs, f.error(err))
+			continue
+		}
+		if err := setDataField(f.resource, f.field, computedValue(buf.String()), copied); err != nil {
+			errs = append(errs, f.error(err))
+		}
+		if i.readsDecrypted(f) {
+			i.derived[f.resource] = true
+		}
+	}
+	return errs
+}
+
+// readsDecrypted returns true if the field reads, through the context, a
+// resource with decrypted values or with computed values derived from them,
+// or may read any resource and the Index has such resources. The fields it
+// refers to are executed before it, so their resources are already known to
+// be derived from decrypted values.
+func (i *Index) readsDecrypted(f *computedField) bool {
+	reads := false
+	walk := func(path []string) {
+		switch {
+		case len(path) > 0 && path[0] == "Self":
+			referenced, _ := i.referenceClosure([]*v1.Resource{f.resource})
+			reads = reads || i.anyDecrypted(append(referenced, f.resource))
+		case len(path) > 0 && (path[0] == "Namespace" || path[0] == "Templates" || path[0] == "Repositories"):
+		case len(path) >= 3 && path[0] == "Resources":
+			if r := i.GetResource(path[1], path[2]); r != nil {
+				reads = reads || i.anyDecrypted([]*v1.Resource{r})
+			}
+		default:
+			reads = reads || i.hasDecrypted()
+		}
+	}
+	for _, t := range f.tmpl.Templates() {
+		walkContext(t.Root, true, walk)
+	}
+	return reads
+}
+
+// computeOrder returns the computed fields in the order they must be
+// executed in, and an error for each cycle of fields referring to each
+// other.
+func (i *Index) computeOrder(fields []*computedField, byResource map[*v1.Resource][]*computedField) ([]*computedField, []error) {
+	errs := []error{}
+	order := []*computedField{}
+	// visiting is true while a field is on the current path, and false once
+	// every field it refers to has been visited
+	visiting := map[*computedField]bool{}
+	path := []*computedField{}
+	var visit func(f *computedField)
+	visit = func(f *computedField) {
+		if v, ok := visiting[f]; ok {
+			if v {
+				// the cycle is the part of the path from the first visit
+				for n := range path {
+					if path[n] == f {
+						ids := []string{}
+						for _, c := range path[n:] {
+							ids = append(ids, c.id())
+						}
+						errs = append(errs, fmt.Errorf("computed field cycle %s -> %s", strings.Join(ids, " -> "), f.id()))
+						break
+					}
+				}
+			}
+			return
+		}
+		visiting[f] = true
+		path = append(path, f)
+		for _, d := range i.computedRefs(f, byResource) {
+			visit(d)
+		}
+		path = path[:len(path)-1]
+		visiting[f] = false
+		order = append(order, f)
+	}
+	for _, f := range fields {
+		visit(f)
+	}
+	return order, errs
+}
+
+// computedRefs returns the computed fields the field refers to through the
+// context: the fields of its own resource it reads through `.Self.data`, the
+// fields of the resources its data refers to with a `$ref`, and the fields
+// of the resources it reads through `.Resources`. A field referring to the
+// context in a way that can not be tied to specific resources refers to
+// every computed field of the other resources.
+func (i *Index) computedRefs(f *computedField, byResource map[*v1.Resource][]*computedField) []*computedField {
+	refs := []*computedField{}
+	// add adds the computed fields of the resource, or only the named field
+	// if it is computed
+	add := func(r *v1.Resource, field string) {
+		for _, c := range byResource[r] {
+			if _, ok := r.Resource.Computed[field]; !ok || c.field == field {
+				refs = append(refs, c)
+			}
+		}
+	}
+	referenced, _ := i.referenceClosure([]*v1.Resource{f.resource})
+	walk := func(path []string) {
+		switch {
+		case len(path) > 0 && path[0] == "Self":
+			if len(path) >= 2 && path[1] != "data" {
+				return
+			}
+			field := ""
+			if len(path) >= 3 {
+				field = path[2]
+			}
+			if _, ok := f.resource.Resource.Computed[field]; ok || field == "" {
+				add(f.resource, field)
+			}
+			if _, ok := f.resource.Resource.Computed[field]; !ok {
+				for _, r := range referenced {
+					add(r, "")
+				}
+			}
+		case len(path) > 0 && (path[0] == "Namespace" || path[0] == "Templates" || path[0] == "Repositories"):
+		case len(path) >= 3 && path[0] == "Resources":
+			r := i.GetResource(path[1], path[2])
+			if r == nil || (len(path) >= 4 && path[3] != "Data") {
+				return
+			}
+			field := ""
+			if len(path) >= 5 {
+				field = path[4]
+			}
+			add(r, field)
+		default:
+			for r := range byResource {
+				if r != f.resource {
+					add(r, "")
+				}
+			}
+		}
+	}
+	for _, t := range f.tmpl.Templates() {
+		walkContext(t.Root, true, walk)
+	}
+	// visit the fields of other resources in a stable order
+	sort.SliceStable(refs, func(a, b int) bool { return refs[a].id() < refs[b].id() })
+	return refs
+}
+
+// computedValue returns the output of a computed field as a number or a
+// boolean if it is one, and as a string otherwise.
+func computedValue(s string) interface{} {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	return s
+}
+
+// setDataField sets a field of the data of the resource, which must be a
+// map. The data is copied the first time a field of the resource is set,
+// where copied are the resources whose data was already copied, so that
+// maps shared with other resources are not modified.
+func setDataField(r *v1.Resource, field string, value interface{}, copied map[*v1.Resource]bool) error {
+	switch data := r.Resource.Data.(type) {
+	case nil:
+		r.Resource.Data = map[interface{}]interface{}{field: value}
+	case map[interface{}]interface{}:
+		if !copied[r] {
+			m := make(map[interface{}]interface{}, len(data)+1)
+			for k, v := range data {
+				m[k] = v
+			}
+			data = m
+			r.Resource.Data = m
+		}
+		data[field] = value
+	case map[string]interface{}:
+		if !copied[r] {
+			m := make(map[string]interface{}, len(data)+1)
+			for k, v := range data {
+				m[k] = v
+			}
+			data = m
+			r.Resource.Data = m
+		}
+		data[field] = value
+	default:
+		return fmt.Errorf("data is not a map")
+	}
+	copied[r] = true
+	return nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_Compute tests the Compute function of the Index, through Load.
+// It expects computed fields to be set in the data of their resource after
+// the fields they refer to, as numbers and booleans when they are, and to be
+// rendered as any other field of the data.
+func Test_Index_Compute(t *testing.T) {
+	i := mustLoad(t, "testdata/056-computed")
+	data := i.GetResource("service", "orders").Resource.Data.(map[interface{}]interface{})
+	expected := map[string]interface{}{
+		"partitions": 3,
+		"replicas":   9,
+		"url":        "postgres://orders.db:5432",
+		"ratio":      84.875,
+		"enabled":    true,
+	}
+	for field, e := range expected {
+		if data[field] != e {
+			t.Errorf("Expected %s to be %v, got %v", field, e, data[field])
+		}
+	}
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "orders 9 postgres://orders.db:5432" {
+		t.Errorf("Expected %q, got %v", "orders 9 postgres://orders.db:5432", artifacts)
+	}
+}
+
+// Test_Index_Compute_Errors tests the Compute function of the Index. It
+// expects an error for computed fields that refer to each other, across
+// resources, for computed fields that fail to execute, and for those reading
+// a missing key.
+func Test_Index_Compute_Errors(t *testing.T) {
+	tests := []struct {
+		computed map[string]map[string]string
+		err      string
+	}{
+		{
+			map[string]map[string]string{
+				"a": {"x": "{{ .Resources.test.b.Data.y }}"},
+				"b": {"y": "{{ .Resources.test.a.Data.x }}"},
+			},
+			"computed field cycle resource:test/a.computed.x -> resource:test/b.computed.y -> resource:test/a.computed.x",
+		},
+		{
+			map[string]map[string]string{
+				"a": {"x": "{{ range .Self.data }}{{ . }}{{ end }}"},
+			},
+			"computed field cycle resource:test/a.computed.x -> resource:test/a.computed.x",
+		},
+		{
+			map[string]map[string]string{
+				"a": {"x": "{{ div 1 0 }}"},
+			},
+			"resource a of kind test: computed field x: template: resource:test/a.computed.x:1:3: executing \"resource:test/a.computed.x\" at <div 1 0>: error calling div: division by zero",
+		},
+		{
+			map[string]map[string]string{
+				"a": {"x": "{{ .Self.name }}", "y": "{{ .Self.data.shrads }}"},
+			},
+			"resource a of kind test: computed field y: template: resource:test/a.computed.y:1:8: executing \"resource:test/a.computed.y\" at <.Self.data.shrads>: map has no entry for key \"shrads\"",
+		},
+	}
+	for _, test := range tests {
+		i := NewIndex()
+		for name, computed := range test.computed {
+			i.AddResource(&v1.Resource{
+				APIVersion: "v1",
+				Resource:   v1.ResourceSpec{Name: name, Kind: "test", Computed: computed},
+			})
+		}
+		errs := i.Compute()
+		if len(errs) != 1 {
+			t.Fatalf("Expected 1 error, got %v", errs)
+		}
+		if errs[0].Error() != test.err {
+			t.Errorf("Expected %s, got %s", test.err, errs[0])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// object is the internal representation of a document, which documents of
+// every API version are converted to when they are loaded. Exactly one of
+// its fields is set. The Index stores documents as their v1 types, so v1 is
+// the hub version: every other version converts to and from it.
+type object struct {
+	resource   *v1.Resource
+	template   *v1.Template
+	repository *v1.Repository
+	generator  *v1.Generator
+	aggregate  *v1.Aggregate
+	policy     *v1.Policy
+}
+
+// document returns the document the object holds, as its v1 type.
+func (o *object) document() interface{} {
+	switch {
+	case o.resource != nil:
+		return o.resource
+	case o.template != nil:
+		return o.template
+	case o.repository != nil:
+		return o.repository
+	case o.generator != nil:
+		return o.generator
+	case o.aggregate != nil:
+		return o.aggregate
+	default:
+		return o.policy
+	}
+}
+
+// converter converts the documents of an API version to and from objects.
+type converter interface {
+	// decode validates a document, unmarshalled as doc from its YAML
+	// content, and converts it to an object.
+	decode(doc map[string]interface{}, content []byte) (*object, []error)
+	// encode converts an object to a document of the API version.
+	encode(o *object) (interface{}, error)
+}
+
+// converters are the converters of the API versions documents can be loaded
+// from, keyed by apiVersion.
+var converters = map[string]converter{
+	"v1": v1Converter{},
+	"v2": v2Converter{},
+}
+
+// decodeDocument converts a document, unmarshalled as doc from its YAML
+// content, to an object with the converter of its API version.
+func decodeDocument(doc map[string]interface{}, content []byte) (*object, []error) {
+	// There must be a known apiVersion
+	apiVersion, ok := doc["apiVersion"].(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("no apiVersion")}
+	}
+	c, ok := converters[apiVersion]
+	if !ok {
+		return nil, []error{fmt.Errorf("invalid apiVersion")}
+	}
+	return c.decode(doc, content)
+}
+
+// v1Converter converts v1 documents, which are already objects.
+type v1Converter struct{}
+
+// decode validates the fields of a v1 document and unmarshals it.
+func (v1Converter) decode(doc map[string]interface{}, content []byte) (*object, []error) {
+	// spec returns the spec of the document under key, if it is a map
+	spec := func(key string) map[interface{}]interface{} {
+		m, _ := doc[key].(map[interface{}]interface{})
+		return m
+	}
+	o := &object{}
+	var checks []func() []error
+	if _, ok := doc["resource"]; ok {
+		// If there is a resource key, unmarshal as Resource
+		o.resource = &v1.Resource{}
+		checks = []func() []error{
+			func() []error { return validateResourceFields(doc) },
+			func() []error { return validateResourceSpecFields(spec("resource")) },
+			func() []error { return validateOutputSpecListFields(spec("resource")["outputs"]) },
+		}
+	} else if _, ok := doc["template"]; ok {
+		// If there is a template key, unmarshal as Template
+		o.template = &v1.Template{}
+		checks = []func() []error{
+			func() []error { return validateTemplateFields(doc) },
+			func() []error { return validateTemplateSpecFields(spec("template")) },
+		}
+	} else if _, ok := doc["repository"]; ok {
+		// If there is a repository key, unmarshal as Repository
+		o.repository = &v1.Repository{}
+		checks = []func() []error{
+			func() []error { return validateRepositoryFields(doc) },
+			func() []error { return validateRepositorySpecFields(spec("repository")) },
+		}
+	} else if _, ok := doc["generator"]; ok {
+		// If there is a generator key, unmarshal as Generator
+		o.generator = &v1.Generator{}
+		checks = []func() []error{
+			func() []error { return validateGeneratorFields(doc) },
+			func() []error { return validateGeneratorSpecFields(spec("generator")) },
+		}
+	} else if _, ok := doc["aggregate"]; ok {
+		// If there is an aggregate key, unmarshal as Aggregate
+		o.aggregate = &v1.Aggregate{}
+		checks = []func() []error{
+			func() []error { return validateAggregateFields(doc) },
+			func() []error { return validateAggregateSpecFields(spec("aggregate")) },
+		}
+	} else if _, ok := doc["policy"]; ok {
+		// If there is a policy key, unmarshal as Policy
+		o.policy = &v1.Policy{}
+		checks = []func() []error{
+			func() []error { return validatePolicyFields(doc) },
+			func() []error { return validatePolicySpecFields(spec("policy")) },
+		}
+	} else {
+		return nil, []error{fmt.Errorf("no resource, template, repository, generator, aggregate or policy")}
+	}
+	for _, check := range checks {
+		if errs := check(); len(errs) > 0 {
+			return nil, errs
+		}
+	}
+	if err := yaml.Unmarshal(content, o.document()); err != nil {
+		return nil, []error{err}
+	}
+	return o, nil
+}
+
+// encode returns the v1 document of the object.
+func (v1Converter) encode(o *object) (interface{}, error) {
+	return o.document(), nil
+}
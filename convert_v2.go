@@ -0,0 +1,330 @@
+package core
+
+import (
+	"fmt"
+
+	v1 "github.com/tpology/core/api/v1"
+	v2 "github.com/tpology/core/api/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// v2Converter converts v2 documents to and from objects.
+type v2Converter struct{}
+
+// decode unmarshals a v2 document, rejecting unknown fields, and converts it
+// to an object.
+func (v2Converter) decode(doc map[string]interface{}, content []byte) (*object, []error) {
+	kind, ok := doc["kind"].(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("no kind")}
+	}
+	o := &object{}
+	var err error
+	switch kind {
+	case v2.ResourceKind:
+		r := v2.Resource{}
+		if err = yaml.UnmarshalStrict(content, &r); err == nil {
+			o.resource, err = resourceFromV2(&r)
+		}
+	case v2.TemplateKind:
+		t := v2.Template{}
+		if err = yaml.UnmarshalStrict(content, &t); err == nil {
+			o.template = templateFromV2(&t)
+		}
+	case v2.RepositoryKind:
+		r := v2.Repository{}
+		if err = yaml.UnmarshalStrict(content, &r); err == nil {
+			o.repository = repositoryFromV2(&r)
+		}
+	case v2.GeneratorKind:
+		g := v2.Generator{}
+		if err = yaml.UnmarshalStrict(content, &g); err == nil {
+			o.generator, err = generatorFromV2(&g)
+		}
+	case v2.AggregateKind:
+		a := v2.Aggregate{}
+		if err = yaml.UnmarshalStrict(content, &a); err == nil {
+			o.aggregate, err = aggregateFromV2(&a)
+		}
+	case v2.PolicyKind:
+		p := v2.Policy{}
+		if err = yaml.UnmarshalStrict(content, &p); err == nil {
+			o.policy = policyFromV2(&p)
+		}
+	default:
+		return nil, []error{fmt.Errorf("unknown kind %s", kind)}
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+	return o, nil
+}
+
+// encode converts an object to a v2 document.
+func (v2Converter) encode(o *object) (interface{}, error) {
+	switch {
+	case o.resource != nil:
+		return resourceToV2(o.resource), nil
+	case o.template != nil:
+		return templateToV2(o.template), nil
+	case o.repository != nil:
+		return repositoryToV2(o.repository), nil
+	case o.generator != nil:
+		return generatorToV2(o.generator), nil
+	case o.aggregate != nil:
+		return aggregateToV2(o.aggregate), nil
+	default:
+		return policyToV2(o.policy), nil
+	}
+}
+
+// typeMetaV2 returns the header of a v2 document of the kind.
+func typeMetaV2(kind string) v2.TypeMeta {
+	return v2.TypeMeta{APIVersion: v2.APIVersion, Kind: kind}
+}
+
+// resourceFromV2 converts a v2 resource to v1.
+func resourceFromV2(r *v2.Resource) (*v1.Resource, error) {
+	outputs, err := outputsFromV2(r.Spec.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	refs := []v1.ReferenceSpec(nil)
+	for _, d := range r.Spec.DependsOn {
+		refs = append(refs, v1.ReferenceSpec{Kind: d.Kind, Name: d.Name, Namespace: d.Namespace})
+	}
+	return &v1.Resource{
+		APIVersion: "v1",
+		Resource: v1.ResourceSpec{
+			Name:        r.Metadata.Name,
+			Kind:        r.Metadata.Kind,
+			Namespace:   r.Metadata.Namespace,
+			Labels:      r.Metadata.Labels,
+			Annotations: r.Metadata.Annotations,
+			Data:        r.Spec.Data,
+			Outputs:     outputs,
+			DependsOn:   refs,
+			Computed:    r.Spec.Computed,
+		},
+	}, nil
+}
+
+// resourceToV2 converts a v1 resource to v2.
+func resourceToV2(r *v1.Resource) *v2.Resource {
+	spec := &r.Resource
+	outputs := outputsToV2(spec.Outputs)
+	refs := []v2.ReferenceSpec(nil)
+	for _, d := range spec.DependsOn {
+		refs = append(refs, v2.ReferenceSpec{Kind: d.Kind, Name: d.Name, Namespace: d.Namespace})
+	}
+	return &v2.Resource{
+		TypeMeta: typeMetaV2(v2.ResourceKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Kind: spec.Kind, Namespace: spec.Namespace, Labels: spec.Labels, Annotations: spec.Annotations},
+		Spec:     v2.ResourceSpec{Data: spec.Data, Outputs: outputs, DependsOn: refs, Computed: spec.Computed},
+	}
+}
+
+// templateFromV2 converts a v2 template to v1.
+func templateFromV2(t *v2.Template) *v1.Template {
+	return &v1.Template{
+		APIVersion: "v1",
+		Template: v1.TemplateSpec{
+			Name:      t.Metadata.Name,
+			Namespace: t.Metadata.Namespace,
+			Content:   t.Spec.Content,
+			File:      t.Spec.File,
+			Engine:    t.Spec.Engine,
+			Parent:    t.Spec.Parent,
+			Functions: t.Spec.Functions,
+		},
+	}
+}
+
+// templateToV2 converts a v1 template to v2.
+func templateToV2(t *v1.Template) *v2.Template {
+	spec := &t.Template
+	return &v2.Template{
+		TypeMeta: typeMetaV2(v2.TemplateKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Namespace: spec.Namespace},
+		Spec: v2.TemplateSpec{
+			Content:   spec.Content,
+			File:      spec.File,
+			Engine:    spec.Engine,
+			Parent:    spec.Parent,
+			Functions: spec.Functions,
+		},
+	}
+}
+
+// repositoryFromV2 converts a v2 repository to v1.
+func repositoryFromV2(r *v2.Repository) *v1.Repository {
+	return &v1.Repository{
+		APIVersion: "v1",
+		Repository: v1.RepositorySpec{
+			Name:        r.Metadata.Name,
+			Namespace:   r.Metadata.Namespace,
+			Repository:  r.Spec.URL,
+			Branch:      r.Spec.Branch,
+			Labels:      r.Metadata.Labels,
+			Annotations: r.Metadata.Annotations,
+		},
+	}
+}
+
+// repositoryToV2 converts a v1 repository to v2.
+func repositoryToV2(r *v1.Repository) *v2.Repository {
+	spec := &r.Repository
+	return &v2.Repository{
+		TypeMeta: typeMetaV2(v2.RepositoryKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Namespace: spec.Namespace, Labels: spec.Labels, Annotations: spec.Annotations},
+		Spec:     v2.RepositorySpec{URL: spec.Repository, Branch: spec.Branch},
+	}
+}
+
+// generatorFromV2 converts a v2 generator to v1.
+func generatorFromV2(g *v2.Generator) (*v1.Generator, error) {
+	outputs, err := outputsFromV2(g.Spec.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Generator{
+		APIVersion: "v1",
+		Generator: v1.GeneratorSpec{
+			Name:        g.Metadata.Name,
+			Selector:    v1.SelectorSpec{Kind: g.Spec.Selector.Kind, Labels: g.Spec.Selector.Labels, Namespace: g.Spec.Selector.Namespace},
+			Labels:      g.Metadata.Labels,
+			Annotations: g.Metadata.Annotations,
+			Outputs:     outputs,
+		},
+	}, nil
+}
+
+// generatorToV2 converts a v1 generator to v2.
+func generatorToV2(g *v1.Generator) *v2.Generator {
+	spec := &g.Generator
+	outputs := outputsToV2(spec.Outputs)
+	return &v2.Generator{
+		TypeMeta: typeMetaV2(v2.GeneratorKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Labels: spec.Labels, Annotations: spec.Annotations},
+		Spec: v2.GeneratorSpec{
+			Selector: v2.SelectorSpec{Kind: spec.Selector.Kind, Labels: spec.Selector.Labels, Namespace: spec.Selector.Namespace},
+			Outputs:  outputs,
+		},
+	}
+}
+
+// aggregateFromV2 converts a v2 aggregate to v1.
+func aggregateFromV2(a *v2.Aggregate) (*v1.Aggregate, error) {
+	outputs, err := outputsFromV2(a.Spec.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	selectors := []v1.SelectorSpec(nil)
+	for _, s := range a.Spec.Selectors {
+		selectors = append(selectors, v1.SelectorSpec{Kind: s.Kind, Labels: s.Labels, Namespace: s.Namespace})
+	}
+	return &v1.Aggregate{
+		APIVersion: "v1",
+		Aggregate: v1.AggregateSpec{
+			Name:        a.Metadata.Name,
+			Selectors:   selectors,
+			Labels:      a.Metadata.Labels,
+			Annotations: a.Metadata.Annotations,
+			Outputs:     outputs,
+		},
+	}, nil
+}
+
+// aggregateToV2 converts a v1 aggregate to v2.
+func aggregateToV2(a *v1.Aggregate) *v2.Aggregate {
+	spec := &a.Aggregate
+	outputs := outputsToV2(spec.Outputs)
+	selectors := []v2.SelectorSpec(nil)
+	for _, s := range spec.Selectors {
+		selectors = append(selectors, v2.SelectorSpec{Kind: s.Kind, Labels: s.Labels, Namespace: s.Namespace})
+	}
+	return &v2.Aggregate{
+		TypeMeta: typeMetaV2(v2.AggregateKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Labels: spec.Labels, Annotations: spec.Annotations},
+		Spec:     v2.AggregateSpec{Selectors: selectors, Outputs: outputs},
+	}
+}
+
+// policyFromV2 converts a v2 policy to v1.
+func policyFromV2(p *v2.Policy) *v1.Policy {
+	rules := []v1.RuleSpec(nil)
+	for _, r := range p.Spec.Rules {
+		rule := v1.RuleSpec{Name: r.Name, Target: r.Target, Condition: r.Condition, Message: r.Message, Severity: r.Severity}
+		if r.Selector != nil {
+			rule.Selector = &v1.SelectorSpec{Kind: r.Selector.Kind, Labels: r.Selector.Labels, Namespace: r.Selector.Namespace}
+		}
+		rules = append(rules, rule)
+	}
+	return &v1.Policy{
+		APIVersion: "v1",
+		Policy: v1.PolicySpec{
+			Name:        p.Metadata.Name,
+			Labels:      p.Metadata.Labels,
+			Annotations: p.Metadata.Annotations,
+			Rules:       rules,
+		},
+	}
+}
+
+// policyToV2 converts a v1 policy to v2.
+func policyToV2(p *v1.Policy) *v2.Policy {
+	spec := &p.Policy
+	rules := []v2.RuleSpec(nil)
+	for _, r := range spec.Rules {
+		rule := v2.RuleSpec{Name: r.Name, Target: r.Target, Condition: r.Condition, Message: r.Message, Severity: r.Severity}
+		if r.Selector != nil {
+			rule.Selector = &v2.SelectorSpec{Kind: r.Selector.Kind, Labels: r.Selector.Labels, Namespace: r.Selector.Namespace}
+		}
+		rules = append(rules, rule)
+	}
+	return &v2.Policy{
+		TypeMeta: typeMetaV2(v2.PolicyKind),
+		Metadata: v2.ObjectMeta{Name: spec.Name, Labels: spec.Labels, Annotations: spec.Annotations},
+		Spec:     v2.PolicySpec{Rules: rules},
+	}
+}
+
+// outputsFromV2 converts v2 outputs to v1. Only file outputs can be
+// converted, as they are the only outputs v1 has.
+func outputsFromV2(outputs []v2.OutputSpec) ([]v1.OutputSpec, error) {
+	converted := []v1.OutputSpec(nil)
+	for _, o := range outputs {
+		if o.Type != "" && o.Type != v2.FileOutputType {
+			return nil, fmt.Errorf("output %s: unknown type %s", o.Name, o.Type)
+		}
+		file := v2.FileOutputSpec{}
+		if o.File != nil {
+			file = *o.File
+		}
+		converted = append(converted, v1.OutputSpec{
+			Name:          o.Name,
+			Repository:    file.Repository,
+			File:          file.Path,
+			Template:      o.Template,
+			Context:       o.Context,
+			PostProcessor: o.PostProcessor,
+		})
+	}
+	return converted, nil
+}
+
+// outputsToV2 converts v1 outputs to v2 file outputs.
+func outputsToV2(outputs []v1.OutputSpec) []v2.OutputSpec {
+	converted := []v2.OutputSpec(nil)
+	for _, o := range outputs {
+		converted = append(converted, v2.OutputSpec{
+			Name:          o.Name,
+			Type:          v2.FileOutputType,
+			Template:      o.Template,
+			Context:       o.Context,
+			PostProcessor: o.PostProcessor,
+			File:          &v2.FileOutputSpec{Repository: o.Repository, Path: o.File},
+		})
+	}
+	return converted
+}
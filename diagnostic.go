@@ -0,0 +1,91 @@
+package core
+
+import (
+	"encoding/json"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Diagnostic is an issue found in the Index when it is loaded or validated.
+type Diagnostic struct {
+	// Severity is the severity of the issue: error, warning or info. Only
+	// errors make the Index invalid.
+	Severity string
+	// Err describes the issue.
+	Err error
+}
+
+// Error implements error
+func (d *Diagnostic) Error() string {
+	return d.Err.Error()
+}
+
+// Unwrap returns the error describing the issue.
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// MarshalJSON encodes the diagnostic as its severity and message.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+	}{d.Severity, d.Error()})
+}
+
+// Diagnostics are the issues found in the Index when it is loaded or
+// validated, in the order they are found.
+type Diagnostics []*Diagnostic
+
+// errorDiagnostics returns errs as diagnostics of error severity.
+func errorDiagnostics(errs []error) Diagnostics {
+	d := Diagnostics{}
+	for _, err := range errs {
+		d = append(d, &Diagnostic{Severity: v1.ErrorSeverity, Err: err})
+	}
+	return d
+}
+
+// HasErrors returns true if any of the diagnostics is an error.
+func (d Diagnostics) HasErrors() bool {
+	for _, diag := range d {
+		if diag.Severity == v1.ErrorSeverity {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns the diagnostics of error severity as errors.
+func (d Diagnostics) Errors() []error {
+	errs := []error{}
+	for _, diag := range d {
+		if diag.Severity == v1.ErrorSeverity {
+			errs = append(errs, diag)
+		}
+	}
+	return errs
+}
+
+// Severity returns the diagnostics of the severity.
+func (d Diagnostics) Severity(severity string) Diagnostics {
+	filtered := Diagnostics{}
+	for _, diag := range d {
+		if diag.Severity == severity {
+			filtered = append(filtered, diag)
+		}
+	}
+	return filtered
+}
+
+// Strict returns the diagnostics with warnings promoted to errors.
+func (d Diagnostics) Strict() Diagnostics {
+	strict := make(Diagnostics, len(d))
+	for n, diag := range d {
+		strict[n] = diag
+		if diag.Severity == v1.WarningSeverity {
+			strict[n] = &Diagnostic{Severity: v1.ErrorSeverity, Err: diag.Err}
+		}
+	}
+	return strict
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_Load_Diagnostics tests the Load function of the Index. It
+// expects the violations of rules of warning severity to be returned as
+// warnings, which do not make the Index invalid unless it is strict.
+func Test_Index_Load_Diagnostics(t *testing.T) {
+	i := NewIndex()
+	diags := i.Load("testdata/057-policies")
+	if len(diags) != 1 || diags.HasErrors() {
+		t.Fatalf("Expected 1 warning, got %v", diags)
+	}
+	if diags[0].Severity != v1.WarningSeverity {
+		t.Errorf("Expected %s, got %s", v1.WarningSeverity, diags[0].Severity)
+	}
+	if len(diags.Severity(v1.WarningSeverity)) != 1 || len(diags.Errors()) != 0 {
+		t.Errorf("Expected 1 warning and 0 errors, got %v", diags)
+	}
+
+	i = NewIndex()
+	i.Strict = true
+	diags = i.Load("testdata/057-policies")
+	if !diags.HasErrors() || len(diags.Errors()) != 1 {
+		t.Fatalf("Expected 1 error, got %v", diags)
+	}
+	if diags.Errors()[0].Error() != "policy platform: rule service-team: resource:service/orders: service orders has no team label" {
+		t.Errorf("Expected service-team violation, got %s", diags.Errors()[0])
+	}
+}
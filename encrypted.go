@@ -0,0 +1,353 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	v1 "github.com/tpology/core/api/v1"
+	v2 "github.com/tpology/core/api/v2"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// EncryptValues encrypts with key the values of the data of the resources in
+// the content of the file at path whose field matches match, and returns the
+// new content and whether any value was encrypted. Values are encrypted as
+// EncryptSecret does, along with their type, such as int or bool, and an
+// Index with the key decrypts them to values of that type when it is
+// validated. Values already encrypted and the fields of references are
+// left as they are. Only the lines of the YAML documents with values to
+// encrypt are rewritten, and the comments of their fields are kept, as
+// Migrate does. An error is returned for JSON and TOML files with values to
+// encrypt.
+func EncryptValues(path string, content []byte, key []byte, match *regexp.Regexp) ([]byte, bool, error) {
+	return rewriteValues(path, content, func(field string, value *yaml3.Node) (bool, error) {
+		if !match.MatchString(field) || IsEncrypted(value.Value) {
+			return false, nil
+		}
+		typ := strings.TrimPrefix(value.ShortTag(), "!!")
+		if !containsString(encryptedTypes, typ) {
+			typ = "str"
+		}
+		encrypted, err := encryptTyped(key, value.Value, typ)
+		if err != nil {
+			return false, err
+		}
+		value.Value, value.Tag, value.Style = encrypted, "!!str", 0
+		return true, nil
+	})
+}
+
+// DecryptValues decrypts with key the values of the data of the resources in
+// the content of the file at path that are encrypted, and returns the new
+// content and whether any value was decrypted, with the type it was
+// encrypted with. Files are rewritten as they are by EncryptValues.
+func DecryptValues(path string, content []byte, key []byte) ([]byte, bool, error) {
+	return rewriteValues(path, content, func(field string, value *yaml3.Node) (bool, error) {
+		if !IsEncrypted(value.Value) {
+			return false, nil
+		}
+		decrypted, typ, err := decryptTyped(key, value.Value)
+		if err != nil {
+			return false, fmt.Errorf("field %s: %s", field, err)
+		}
+		value.Value, value.Tag, value.Style = decrypted, "!!"+typ, 0
+		return true, nil
+	})
+}
+
+// rewriteValues calls fn with every scalar value of the data of the
+// resources in the content of the file at path, along with the field it is
+// the value of or an item of, and rewrites the documents for which fn
+// returns true.
+func rewriteValues(path string, content []byte, fn func(field string, value *yaml3.Node) (bool, error)) ([]byte, bool, error) {
+	if !isDocument(path) {
+		return content, false, nil
+	}
+	docs, err := readDocuments(path, content)
+	if err != nil {
+		return nil, false, err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	out := strings.Builder{}
+	next := 0
+	changed := false
+	yamlFile := documentFormats[filepath.Ext(path)] == nil
+	for _, d := range docs {
+		// the lines of a YAML document exclude the comments around it, which
+		// are kept as they are
+		start, end := d.source.StartLine-1, d.source.EndLine
+		doc := d.content
+		if yamlFile {
+			doc = []byte(strings.Join(lines[start:end], ""))
+		}
+		node := yaml3.Node{}
+		if err := yaml3.Unmarshal(doc, &node); err != nil {
+			return nil, false, fmt.Errorf("%s: %s", d.source, err)
+		}
+		data := resourceDataNode(&node)
+		if data == nil {
+			continue
+		}
+		rewritten, err := rewriteNode(data, "data", fn)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %s", d.source, err)
+		}
+		if !rewritten {
+			continue
+		}
+		if !yamlFile {
+			return nil, false, fmt.Errorf("%s: values can only be rewritten in YAML files", d.source)
+		}
+		buf := bytes.Buffer{}
+		e := yaml3.NewEncoder(&buf)
+		e.SetIndent(2)
+		if err := e.Encode(&node); err != nil {
+			return nil, false, fmt.Errorf("%s: %s", d.source, err)
+		}
+		if err := e.Close(); err != nil {
+			return nil, false, fmt.Errorf("%s: %s", d.source, err)
+		}
+		out.WriteString(strings.Join(lines[next:start], ""))
+		out.Write(buf.Bytes())
+		next = end
+		changed = true
+	}
+	if !changed {
+		return content, false, nil
+	}
+	out.WriteString(strings.Join(lines[next:], ""))
+	return []byte(out.String()), true, nil
+}
+
+// resourceDataNode returns the node of the data of the v1 or v2 resource
+// document, or nil if the document is not a resource or has no data.
+func resourceDataNode(doc *yaml3.Node) *yaml3.Node {
+	if doc.Kind != yaml3.DocumentNode || len(doc.Content) != 1 {
+		return nil
+	}
+	root := doc.Content[0]
+	if spec := mappingValue(root, "resource"); spec != nil {
+		return mappingValue(spec, "data")
+	}
+	if kind := mappingValue(root, "kind"); kind != nil && kind.Value == v2.ResourceKind {
+		return mappingValue(mappingValue(root, "spec"), "data")
+	}
+	return nil
+}
+
+// mappingValue returns the value of the field of the mapping node, or nil if
+// node is not a mapping or has no such field.
+func mappingValue(node *yaml3.Node, field string) *yaml3.Node {
+	if node == nil || node.Kind != yaml3.MappingNode {
+		return nil
+	}
+	for n := 0; n+1 < len(node.Content); n += 2 {
+		if node.Content[n].Value == field {
+			return node.Content[n+1]
+		}
+	}
+	return nil
+}
+
+// rewriteNode calls fn with every scalar value under node, which is the
+// value of field or an item of it, and returns true if fn does for any.
+// References to resources and secrets are skipped.
+func rewriteNode(node *yaml3.Node, field string, fn func(field string, value *yaml3.Node) (bool, error)) (bool, error) {
+	switch node.Kind {
+	case yaml3.ScalarNode:
+		if node.Tag == "!!null" {
+			return false, nil
+		}
+		return fn(field, node)
+	case yaml3.MappingNode:
+		if len(node.Content) == 2 && (node.Content[0].Value == v1.RefKey || node.Content[0].Value == v1.SecretKey) {
+			return false, nil
+		}
+		rewritten := false
+		for n := 0; n+1 < len(node.Content); n += 2 {
+			r, err := rewriteNode(node.Content[n+1], node.Content[n].Value, fn)
+			if err != nil {
+				return false, err
+			}
+			rewritten = rewritten || r
+		}
+		return rewritten, nil
+	case yaml3.SequenceNode:
+		rewritten := false
+		for _, item := range node.Content {
+			r, err := rewriteNode(item, field, fn)
+			if err != nil {
+				return false, err
+			}
+			rewritten = rewritten || r
+		}
+		return rewritten, nil
+	}
+	return false, nil
+}
+
+// decrypt decrypts the encrypted values in the data of the resources of the
+// Index with its DecryptionKey, recording their ciphertext so that Export
+// keeps them encrypted. The data of a resource is copied before its values
+// are decrypted, so that maps shared with other resources are not modified.
+// Without a key, each encrypted value is reported as a warning and left
+// encrypted.
+func (i *Index) decrypt() Diagnostics {
+	d := Diagnostics{}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			r := i.resourceByKind[kind][name]
+			if !hasEncrypted(r.Resource.Data) {
+				continue
+			}
+			if i.DecryptionKey == nil {
+				walkData(r.Resource.Data, "data", func(path string, v interface{}) bool {
+					if s, ok := v.(string); ok && IsEncrypted(s) {
+						d = append(d, &Diagnostic{Severity: v1.WarningSeverity, Err: fmt.Errorf("resource %s of kind %s: %s: value is encrypted and there is no decryption key", name, kind, path)})
+					}
+					return true
+				})
+				continue
+			}
+			ciphertexts := i.encrypted[r]
+			if ciphertexts == nil {
+				ciphertexts = map[string]string{}
+			}
+			data, err := decryptData(i.DecryptionKey, r.Resource.Data, "data", ciphertexts)
+			if err != nil {
+				d = append(d, &Diagnostic{Severity: v1.ErrorSeverity, Err: fmt.Errorf("resource %s of kind %s: %s", name, kind, err)})
+				continue
+			}
+			r.Resource.Data = data
+			i.encrypted[r] = ciphertexts
+		}
+	}
+	return d
+}
+
+// decryptData returns a copy of data at path with its encrypted values
+// decrypted with key to values of the type they were encrypted with,
+// recording their ciphertext in ciphertexts by path.
+func decryptData(key []byte, data interface{}, path string, ciphertexts map[string]string) (interface{}, error) {
+	var err error
+	switch v := data.(type) {
+	case string:
+		if !IsEncrypted(v) {
+			return v, nil
+		}
+		decrypted, typ, err := decryptTyped(key, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		ciphertexts[path] = v
+		if typ == "str" {
+			return decrypted, nil
+		}
+		// other types are decoded as the data of resources is
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(decrypted), &value); err != nil {
+			return nil, fmt.Errorf("%s: invalid encrypted %s: %s", path, typ, err)
+		}
+		return value, nil
+	case map[interface{}]interface{}:
+		// the keys are sorted, so that the error returned is that of the
+		// first value that fails, as with map[string]interface{}
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(a, b int) bool { return fmt.Sprint(keys[a]) < fmt.Sprint(keys[b]) })
+		m := make(map[interface{}]interface{}, len(v))
+		for _, k := range keys {
+			if m[k], err = decryptData(key, v[k], fmt.Sprintf("%s.%v", path, k), ciphertexts); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		m := make(map[string]interface{}, len(v))
+		for _, k := range keys {
+			if m[k], err = decryptData(key, v[k], path+"."+k, ciphertexts); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for n, e := range v {
+			if l[n], err = decryptData(key, e, fmt.Sprintf("%s[%d]", path, n), ciphertexts); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	}
+	return data, nil
+}
+
+// encryptedData returns a copy of data at path with the values decrypted
+// from ciphertexts replaced by their ciphertext.
+func encryptedData(data interface{}, path string, ciphertexts map[string]string) interface{} {
+	if c, ok := ciphertexts[path]; ok {
+		return c
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			m[k] = encryptedData(e, fmt.Sprintf("%s.%v", path, k), ciphertexts)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = encryptedData(e, path+"."+k, ciphertexts)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for n, e := range v {
+			l[n] = encryptedData(e, fmt.Sprintf("%s[%d]", path, n), ciphertexts)
+		}
+		return l
+	}
+	return data
+}
+
+// anyDecrypted returns true if values were decrypted in the data of any of
+// the resources, or computed from decrypted values.
+func (i *Index) anyDecrypted(resources []*v1.Resource) bool {
+	for _, r := range resources {
+		if len(i.encrypted[r]) > 0 || i.derived[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDecrypted returns true if values were decrypted in the data of any
+// resource of the Index, or computed from decrypted values.
+func (i *Index) hasDecrypted() bool {
+	return len(i.encrypted) > 0 || len(i.derived) > 0
+}
+
+// hasEncrypted returns true if there is an encrypted value in data.
+func hasEncrypted(data interface{}) bool {
+	found := false
+	walkData(data, "data", func(path string, v interface{}) bool {
+		if s, ok := v.(string); ok && IsEncrypted(s) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Test_EncryptValues tests the EncryptValues and DecryptValues functions. It
+// expects the values of the matching fields of the data of resources to be
+// encrypted, including the items of lists, but not references or other
+// documents, and to be decrypted back to the same content, with the same
+// types.
+func Test_EncryptValues(t *testing.T) {
+	content := `# the model
+apiVersion: v1
+template:
+  name: password
+  content: hunter2
+---
+apiVersion: v2
+kind: Resource
+metadata:
+  name: orders
+  kind: service
+spec:
+  data:
+    user: orders
+    # the password of the database
+    password: hunter2
+    port: "5432"
+    replicas: 3
+    ratio: 0.5
+    enabled: true
+    tokens:
+      - abc
+      - def
+    key:
+      $secret: {provider: file, key: api/key}
+`
+	key, _ := NewSecretKey()
+	match := regexp.MustCompile(`^(password|port|replicas|ratio|enabled|tokens|key)$`)
+	encrypted, changed, err := EncryptValues("model.yaml", []byte(content), key, match)
+	if err != nil || !changed {
+		t.Fatalf("Expected the values to be encrypted, got %v, %v", changed, err)
+	}
+	for _, s := range []string{"# the model", "content: hunter2", "# the password of the database", "user: orders", "{provider: file, key: api/key}"} {
+		if !strings.Contains(string(encrypted), s) {
+			t.Errorf("Expected %q to be kept, got %s", s, encrypted)
+		}
+	}
+	for _, s := range []string{"password: hunter2", `port: "5432"`, "replicas: 3", "ratio: 0.5", "enabled: true", "- abc", "- def"} {
+		if strings.Contains(string(encrypted), s) {
+			t.Errorf("Expected %q to be encrypted, got %s", s, encrypted)
+		}
+	}
+	if _, changed, _ := EncryptValues("model.yaml", encrypted, key, match); changed {
+		t.Errorf("Expected encrypted values not to be encrypted again")
+	}
+
+	decrypted, changed, err := DecryptValues("model.yaml", encrypted, key)
+	if err != nil || !changed {
+		t.Fatalf("Expected the values to be decrypted, got %v, %v", changed, err)
+	}
+	if string(decrypted) != content {
+		t.Errorf("Expected %s, got %s", content, decrypted)
+	}
+
+	other, _ := NewSecretKey()
+	if _, _, err := DecryptValues("model.yaml", encrypted, other); err == nil || !strings.HasSuffix(err.Error(), "field password: value can not be decrypted with the key") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+	if _, _, err := EncryptValues("model.json", []byte(`{"apiVersion": "v1", "resource": {"name": "a", "kind": "b", "data": {"password": "x"}}}`), key, match); err == nil || !strings.HasSuffix(err.Error(), "values can only be rewritten in YAML files") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
+
+// Test_Index_Load_Encrypted tests the Load function of the Index with
+// encrypted values. It expects them to be decrypted with the key of the
+// Index to values of their type, and exported encrypted, and a warning for
+// each of them without a key.
+func Test_Index_Load_Encrypted(t *testing.T) {
+	i := NewIndex()
+	diags := i.Load("testdata/064-encrypted")
+	expected := []string{
+		"resource orders of kind service: data.password: value is encrypted and there is no decryption key",
+		"resource orders of kind service: data.port: value is encrypted and there is no decryption key",
+		"resource orders of kind service: data.tokens[0]: value is encrypted and there is no decryption key",
+		"resource orders of kind service: data.tokens[1]: value is encrypted and there is no decryption key",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), diags)
+	}
+	for n, e := range expected {
+		if diags[n].Severity != v1.WarningSeverity || diags[n].Error() != e {
+			t.Errorf("Expected warning %s, got %s %s", e, diags[n].Severity, diags[n])
+		}
+	}
+	exported := i.Export().Resources[0].Data
+
+	key, err := ReadSecretKey("testdata/064-encrypted.key")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	i = NewIndex()
+	i.DecryptionKey = key
+	if diags := i.Load("testdata/064-encrypted"); len(diags) != 0 {
+		t.Fatalf("Expected 0 diagnostics, got %v", diags)
+	}
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "orders:hunter2 abc def " {
+		t.Errorf("Expected the values to be decrypted, got %v", artifacts)
+	}
+	if port := i.GetResource("service", "orders").Resource.Data.(map[interface{}]interface{})["port"]; port != 5432 {
+		t.Errorf("Expected the port to be decrypted to 5432, got %#v", port)
+	}
+	data := i.Export().Resources[0].Data.(map[interface{}]interface{})
+	password := data["password"].(string)
+	if password != exported.(map[interface{}]interface{})["password"] {
+		t.Errorf("Expected the password to be exported encrypted, got %s", password)
+	}
+	if token := data["tokens"].([]interface{})[1].(string); !IsEncrypted(token) {
+		t.Errorf("Expected the token to be exported encrypted, got %s", token)
+	}
+	if port, ok := data["port"].(string); !ok || !IsEncrypted(port) {
+		t.Errorf("Expected the port to be exported encrypted, got %v", data["port"])
+	}
+
+	i = NewIndex()
+	i.DecryptionKey, _ = NewSecretKey()
+	diags = i.Load("testdata/064-encrypted")
+	if !diags.HasErrors() || diags[0].Error() != "resource orders of kind service: data.password: value can not be decrypted with the key" {
+		t.Errorf("Expected an error, got %v", diags)
+	}
+}
+
+// decryptedIndex is a helper function that returns an Index with the key
+// decrypting the password of a database, which a service reads through a
+// computed field, and an output of another service for each template, except
+// for the computed template, whose output is that of the first service.
+func decryptedIndex(t *testing.T, templates map[string]string) *Index {
+	key, _ := NewSecretKey()
+	password, err := encryptTyped(key, "hunter2", "str")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	i := NewIndex()
+	i.DecryptionKey = key
+	for name, content := range templates {
+		if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: name, Content: content}}); err != nil {
+			t.Fatalf("Failed to add template: %s", err)
+		}
+	}
+	// the computed template is rendered for the service with the computed
+	// field, and the others for a service without
+	outputs := map[string][]v1.OutputSpec{}
+	for name := range templates {
+		resource := "billing"
+		if name == "computed" {
+			resource = "orders"
+		}
+		outputs[resource] = append(outputs[resource], v1.OutputSpec{Name: name, Repository: "repo-1", File: name, Template: name})
+	}
+	for _, spec := range []v1.ResourceSpec{
+		{Name: "db", Kind: "database", Data: map[interface{}]interface{}{"user": "u", "password": password}},
+		{Name: "orders", Kind: "service", Computed: map[string]string{"url": "pg://u:{{ .Resources.database.db.Data.password }}@h"}, Outputs: outputs["orders"]},
+		{Name: "billing", Kind: "service", Outputs: outputs["billing"]},
+	} {
+		if err := i.AddResource(&v1.Resource{APIVersion: "v1", Resource: spec}); err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	if diags := i.Validate(); len(diags) != 0 {
+		t.Fatalf("Expected 0 diagnostics, got %v", diags)
+	}
+	return i
+}
+
+// Test_Index_Export_Decrypted tests the Export function of the Index. It
+// expects neither decrypted values nor computed values derived from them to
+// be exported, and the computed values to be computed again on Import.
+func Test_Index_Export_Decrypted(t *testing.T) {
+	i := decryptedIndex(t, map[string]string{})
+	if url := i.GetResource("service", "orders").Resource.Data.(map[interface{}]interface{})["url"]; url != "pg://u:hunter2@h" {
+		t.Fatalf("Expected the url to be computed, got %v", url)
+	}
+	b := i.Export()
+	content, err := yaml.Marshal(b)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if strings.Contains(string(content), "hunter2") {
+		t.Errorf("Expected decrypted values not to be exported, got %s", content)
+	}
+
+	imported := NewIndex()
+	imported.DecryptionKey = i.DecryptionKey
+	if diags := imported.Import(b); len(diags) != 0 {
+		t.Fatalf("Expected 0 diagnostics, got %v", diags)
+	}
+	if url := imported.GetResource("service", "orders").Resource.Data.(map[interface{}]interface{})["url"]; url != "pg://u:hunter2@h" {
+		t.Errorf("Expected the url to be computed on Import, got %v", url)
+	}
+}
+
+// Test_Renderer_Render_CacheDecrypted tests the Render function of the
+// Renderer with a Cache. It expects outputs reading decrypted values, or
+// values computed from them, through the context not to be cached.
+func Test_Renderer_Render_CacheDecrypted(t *testing.T) {
+	i := decryptedIndex(t, map[string]string{
+		"reads":    "{{ .Resources.database.db.Data.password }}",
+		"all":      "{{ range .Resources.database }}{{ .Data.password }}{{ end }}",
+		"computed": "{{ .Self.data.url }}",
+		"plain":    "{{ .Self.name }}",
+	})
+	dir := t.TempDir()
+	c, err := OpenRenderCache(dir)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	artifacts, errs := r.Render(context.Background())
+	if len(errs) != 0 || len(artifacts) != 4 {
+		t.Fatalf("Expected 4 artifacts, got %v, %v", artifacts, errs)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected only the plain output to be cached, got %v", files)
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil || strings.Contains(string(b), "hunter2") {
+		t.Errorf("Expected the decrypted value not to be cached, got %s", b)
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// DefaultEngine is the name of the engine used by templates that do not
+// declare one.
+const DefaultEngine = "text"
+
+// Executable is a compiled template that can be executed with some data.
+type Executable interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Engine compiles templates.
+type Engine interface {
+	// Compile compiles the named template, using lookup to find it and any
+	// other template it refers to.
+	Compile(name string, lookup TemplateLookup) (Executable, error)
+}
+
+// StrictEngine is an Engine that can also compile templates that fail when
+// they read a key missing from a map in the data, rather than rendering a
+// zero value such as "<no value>". Templates of engines that are not
+// StrictEngines are compiled with Compile when strict templates are
+// requested.
+type StrictEngine interface {
+	Engine
+	// CompileStrict compiles the named template like Compile, with missing
+	// keys being errors.
+	CompileStrict(name string, lookup TemplateLookup) (Executable, error)
+}
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]Engine{
+		"text":     textEngine{},
+		"html":     htmlEngine{},
+		"envsubst": envsubstEngine{},
+	}
+)
+
+// RegisterEngine makes an engine available to templates under name,
+// replacing any engine already registered under that name.
+func RegisterEngine(name string, e Engine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	engines[name] = e
+}
+
+// Engines returns the names of the registered engines in sorted order.
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupEngine returns the named engine, or the default engine if name is
+// empty.
+func lookupEngine(name string) (Engine, error) {
+	if name == "" {
+		name = DefaultEngine
+	}
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	e, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("engine %s does not exist", name)
+	}
+	return e, nil
+}
+
+// textEngine compiles templates with text/template. Layouts and partials are
+// compiled with the engine of the template that refers to them.
+type textEngine struct{}
+
+// Compile implements Engine
+func (e textEngine) Compile(name string, lookup TemplateLookup) (Executable, error) {
+	return e.compile(name, lookup, "missingkey=default")
+}
+
+// CompileStrict implements StrictEngine
+func (e textEngine) CompileStrict(name string, lookup TemplateLookup) (Executable, error) {
+	return e.compile(name, lookup, "missingkey=error")
+}
+
+// compile compiles the named template with the missingkey option.
+func (textEngine) compile(name string, lookup TemplateLookup, missingKey string) (Executable, error) {
+	specs, root, err := resolveTemplate(name, lookup)
+	if err != nil {
+		return nil, err
+	}
+	var set *template.Template
+	set = template.New(root).Option(missingKey).Funcs(template.FuncMap{
+		"include": func(name string, data interface{}) (string, error) {
+			buf := bytes.Buffer{}
+			err := set.ExecuteTemplate(&buf, name, data)
+			return buf.String(), err
+		},
+	})
+	err = addParseTrees(specs, func(name string, tree *parse.Tree) error {
+		_, err := set.AddParseTree(name, tree)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// htmlEngine compiles templates with html/template, so that values are
+// escaped for the context they appear in. Layouts and partials are compiled
+// with the engine of the template that refers to them.
+type htmlEngine struct{}
+
+// Compile implements Engine
+func (e htmlEngine) Compile(name string, lookup TemplateLookup) (Executable, error) {
+	return e.compile(name, lookup, "missingkey=default")
+}
+
+// CompileStrict implements StrictEngine
+func (e htmlEngine) CompileStrict(name string, lookup TemplateLookup) (Executable, error) {
+	return e.compile(name, lookup, "missingkey=error")
+}
+
+// compile compiles the named template with the missingkey option.
+func (htmlEngine) compile(name string, lookup TemplateLookup, missingKey string) (Executable, error) {
+	specs, root, err := resolveTemplate(name, lookup)
+	if err != nil {
+		return nil, err
+	}
+	var set *htmltemplate.Template
+	set = htmltemplate.New(root).Option(missingKey).Funcs(htmltemplate.FuncMap{
+		"include": func(name string, data interface{}) (htmltemplate.HTML, error) {
+			buf := bytes.Buffer{}
+			err := set.ExecuteTemplate(&buf, name, data)
+			return htmltemplate.HTML(buf.String()), err
+		},
+	})
+	err = addParseTrees(specs, func(name string, tree *parse.Tree) error {
+		// html/template rewrites the trees it escapes, so give it a copy
+		_, err := set.AddParseTree(name, tree.Copy())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// unlike text/template, adding the tree of the root to the set does not
+	// make it the tree of the set, so look it up
+	return set.Lookup(root), nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// execute is a helper function that adds the templates to a new Index and
+// executes the named one with a DefaultContext for self.
+func execute(t *testing.T, name string, self map[string]interface{}, templates ...v1.TemplateSpec) (string, error) {
+	i := NewIndex()
+	for n := range templates {
+		if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: templates[n]}); err != nil {
+			t.Fatalf("Failed to add template: %s", err)
+		}
+	}
+	tmpl, err := i.parsedTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	data := i.defaultContext()
+	data.Self = self
+	buf := bytes.Buffer{}
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
+
+// Test_Engine_HTML tests the html engine. It expects values to be escaped,
+// but not the content of included partials.
+func Test_Engine_HTML(t *testing.T) {
+	out, err := execute(t, "page", map[string]interface{}{"name": "<b>x</b>"},
+		v1.TemplateSpec{Name: "page", Engine: "html", Content: `<p>{{ .Self.name }}</p>{{ include "footer" . }}`},
+		v1.TemplateSpec{Name: "footer", Content: `<i>{{ .Self.name }}</i>`},
+	)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if out != "<p>&lt;b&gt;x&lt;/b&gt;</p><i>&lt;b&gt;x&lt;/b&gt;</i>" {
+		t.Errorf("Expected escaped output, got %s", out)
+	}
+}
+
+// Test_Engine_Envsubst tests the envsubst engine. It expects paths, defaults
+// and escaped dollars to be substituted.
+func Test_Engine_Envsubst(t *testing.T) {
+	out, err := execute(t, "env", map[string]interface{}{"name": "resource-1", "data": map[interface{}]interface{}{"port": 8080}},
+		v1.TemplateSpec{Name: "env", Engine: "envsubst", Content: "name=${Self.name} port=${self.data.port} host=${Self.data.host:-localhost} cost=$$5 tmpl=${Templates.env.Engine}"},
+	)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if out != "name=resource-1 port=8080 host=localhost cost=$5 tmpl=envsubst" {
+		t.Errorf("Expected substituted output, got %s", out)
+	}
+	_, err = execute(t, "env", nil, v1.TemplateSpec{Name: "env", Engine: "envsubst", Content: "${Self.missing}"})
+	if err == nil || err.Error() != "template: env: ${Self.missing} is not defined" {
+		t.Errorf("Expected template: env: ${Self.missing} is not defined, got %v", err)
+	}
+	_, err = execute(t, "env", nil, v1.TemplateSpec{Name: "env", Engine: "envsubst", Content: "line 1\n${Self.name"})
+	if err == nil || err.Error() != "template: env:2: unclosed substitution" {
+		t.Errorf("Expected template: env:2: unclosed substitution, got %v", err)
+	}
+	_, err = execute(t, "env", nil, v1.TemplateSpec{Name: "env", Engine: "envsubst", Content: "line 1\n${Self.host:-first\nsecond}\nline 4\n${}"})
+	if err == nil || err.Error() != "template: env:5: empty substitution" {
+		t.Errorf("Expected template: env:5: empty substitution, got %v", err)
+	}
+}
+
+// upperEngine is an Engine that renders the content of a template in upper
+// case.
+type upperEngine struct{}
+
+// Compile implements Engine
+func (upperEngine) Compile(name string, lookup TemplateLookup) (Executable, error) {
+	return upperTemplate(lookup(name).Content), nil
+}
+
+// upperTemplate is the Executable of upperEngine.
+type upperTemplate string
+
+// Execute implements Executable
+func (u upperTemplate) Execute(w io.Writer, data interface{}) error {
+	_, err := io.WriteString(w, strings.ToUpper(string(u)))
+	return err
+}
+
+// Test_RegisterEngine tests the RegisterEngine function. It expects a
+// template to be compiled by the registered engine.
+func Test_RegisterEngine(t *testing.T) {
+	RegisterEngine("upper", upperEngine{})
+	out, err := execute(t, "template-1", nil, v1.TemplateSpec{Name: "template-1", Engine: "upper", Content: "test"})
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if out != "TEST" {
+		t.Errorf("Expected TEST, got %s", out)
+	}
+}
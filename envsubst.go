@@ -0,0 +1,161 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// envsubstEngine compiles templates that substitute `${path}` with the value
+// found by following the dot separated path into the data, in the style of
+// envsubst. `${path:-default}` substitutes default if the path does not
+// resolve to a value, and `$$` is a literal `$`. Layouts and partials are not
+// supported.
+type envsubstEngine struct{}
+
+// Compile implements Engine
+func (envsubstEngine) Compile(name string, lookup TemplateLookup) (Executable, error) {
+	spec := lookup(name)
+	if spec == nil {
+		return nil, fmt.Errorf("template %s does not exist", name)
+	}
+	if spec.Parent != "" {
+		return nil, fmt.Errorf("template %s: engine envsubst does not support parent", name)
+	}
+	return parseEnvsubst(name, spec.Content)
+}
+
+// envsubstTemplate is a parsed envsubst template. It is a list of literal
+// text and substitutions.
+type envsubstTemplate struct {
+	name  string
+	parts []envsubstPart
+}
+
+// envsubstPart is either literal text or a substitution.
+type envsubstPart struct {
+	text string
+	// path is the path to substitute, or nil for literal text
+	path       []string
+	def        string
+	hasDefault bool
+}
+
+// parseEnvsubst parses the content of an envsubst template.
+func parseEnvsubst(name string, content string) (*envsubstTemplate, error) {
+	t := &envsubstTemplate{name: name}
+	text := strings.Builder{}
+	line := 1
+	for n := 0; n < len(content); n++ {
+		c := content[n]
+		if c == '\n' {
+			line++
+		}
+		if c != '$' || n+1 == len(content) {
+			text.WriteByte(c)
+			continue
+		}
+		switch content[n+1] {
+		case '$':
+			text.WriteByte('$')
+			n++
+		case '{':
+			end := strings.IndexByte(content[n:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("template: %s:%d: unclosed substitution", name, line)
+			}
+			expr := content[n+2 : n+end]
+			part := envsubstPart{}
+			if d := strings.Index(expr, ":-"); d >= 0 {
+				part.def = expr[d+2:]
+				part.hasDefault = true
+				expr = expr[:d]
+			}
+			if expr == "" {
+				return nil, fmt.Errorf("template: %s:%d: empty substitution", name, line)
+			}
+			part.path = strings.Split(expr, ".")
+			for _, p := range part.path {
+				if p == "" {
+					return nil, fmt.Errorf("template: %s:%d: invalid substitution ${%s}", name, line, expr)
+				}
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, envsubstPart{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, part)
+			// the newlines of a default spanning lines are skipped with it
+			line += strings.Count(content[n:n+end], "\n")
+			n += end
+		default:
+			text.WriteByte(c)
+		}
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, envsubstPart{text: text.String()})
+	}
+	return t, nil
+}
+
+// Execute implements Executable
+func (t *envsubstTemplate) Execute(w io.Writer, data interface{}) error {
+	for _, p := range t.parts {
+		s := p.text
+		if p.path != nil {
+			v, ok := resolvePath(reflect.ValueOf(data), p.path)
+			switch {
+			case ok:
+				s = fmt.Sprint(v.Interface())
+			case p.hasDefault:
+				s = p.def
+			default:
+				return fmt.Errorf("template: %s: ${%s} is not defined", t.name, strings.Join(p.path, "."))
+			}
+		}
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePath follows path into v through struct fields, which can be named
+// by their Go or YAML name, and map keys. It returns false if the path does
+// not resolve to a value.
+func resolvePath(v reflect.Value, path []string) (reflect.Value, bool) {
+	for _, key := range path {
+		for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+			v = v.Elem()
+		}
+		if !v.IsValid() {
+			return v, false
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			f := v.FieldByName(key)
+			if !f.IsValid() {
+				for n := 0; n < v.NumField(); n++ {
+					if strings.Split(v.Type().Field(n).Tag.Get("yaml"), ",")[0] == key {
+						f = v.Field(n)
+						break
+					}
+				}
+			}
+			v = f
+		case reflect.Map:
+			k := reflect.ValueOf(key)
+			if !k.Type().ConvertibleTo(v.Type().Key()) {
+				return reflect.Value{}, false
+			}
+			v = v.MapIndex(k.Convert(v.Type().Key()))
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	return v, v.IsValid()
+}
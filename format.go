@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// documentFormats maps the extension of the files documents are loaded from
+// to a function converting their content to YAML.
+var documentFormats = map[string]func(content []byte) ([]byte, error){
+	".yaml": nil,
+	".yml":  nil,
+	".json": jsonToYAML,
+	".toml": tomlToYAML,
+}
+
+// isDocument returns true if documents are loaded from the file at path.
+func isDocument(path string) bool {
+	_, ok := documentFormats[filepath.Ext(path)]
+	return ok
+}
+
+// document is a document read from a file, as YAML.
+type document struct {
+	content []byte
+	source  v1.SourceSpec
+}
+
+// readDocuments returns the documents in the content of the file at path. A
+// YAML file may hold several documents separated by `---`, while JSON and
+// TOML files hold one, which is converted to YAML so that documents of every
+// format are validated and unmarshalled in the same way. Parse errors are
+// reported with positions in the original content.
+func readDocuments(path string, content []byte) ([]document, error) {
+	convert := documentFormats[filepath.Ext(path)]
+	if convert == nil {
+		return splitYAML(path, content), nil
+	}
+	yamlContent, err := convert(content)
+	if err != nil {
+		return nil, err
+	}
+	return []document{{
+		content: yamlContent,
+		source:  v1.SourceSpec{File: path, StartLine: 1, EndLine: countLines(content)},
+	}}, nil
+}
+
+// splitYAML splits YAML content into its documents. Documents holding only
+// comments are skipped, unless there is no other document. A separator may
+// be followed on its line by the start of the next document.
+func splitYAML(path string, content []byte) []document {
+	lines := strings.SplitAfter(string(content), "\n")
+	docs := []document{}
+	start := 0
+	// add adds the document made of the lines from start to end
+	add := func(end int) {
+		first, last := -1, -1
+		for n := start; n < end; n++ {
+			line := strings.TrimSpace(lines[n])
+			if line != "" && !strings.HasPrefix(line, "#") {
+				if first < 0 {
+					first = n
+				}
+				last = n
+			}
+		}
+		if first < 0 {
+			return
+		}
+		// start the document with empty lines so that YAML errors report
+		// the lines of the file
+		docs = append(docs, document{
+			content: []byte(strings.Repeat("\n", start) + strings.Join(lines[start:end], "")),
+			source:  v1.SourceSpec{File: path, Document: len(docs), StartLine: first + 1, EndLine: last + 1},
+		})
+	}
+	for n, line := range lines {
+		switch {
+		case line == "---" || strings.HasPrefix(line, "---\n") || strings.HasPrefix(line, "---\r"):
+			add(n)
+			start = n + 1
+		case strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "---\t"):
+			// the rest of the line, such as `--- {a: 1}` or `--- !tag`, is
+			// the start of the next document
+			add(n)
+			start = n
+			lines[n] = strings.TrimLeft(line[3:], " \t")
+		}
+	}
+	add(len(lines))
+	if len(docs) == 0 {
+		docs = append(docs, document{content: content, source: v1.SourceSpec{File: path, StartLine: 1, EndLine: countLines(content)}})
+	}
+	return docs
+}
+
+// countLines returns the number of lines in content, which is at least 1.
+func countLines(content []byte) int {
+	n := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		n++
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// jsonToYAML converts a JSON document to YAML.
+func jsonToYAML(content []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(content))
+	d.UseNumber()
+	var doc map[string]interface{}
+	if err := d.Decode(&doc); err != nil {
+		offset := d.InputOffset()
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			// the offset is after the byte the error is at
+			offset = err.Offset - 1
+		case *json.UnmarshalTypeError:
+			offset = err.Offset - 1
+		}
+		if err == io.ErrUnexpectedEOF {
+			offset = int64(len(content))
+		}
+		line, column := position(content, offset)
+		return nil, fmt.Errorf("json: line %d, column %d: %s", line, column, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if d.More() {
+		line, column := position(content, d.InputOffset())
+		return nil, fmt.Errorf("json: line %d, column %d: unexpected content after document", line, column)
+	}
+	return yaml.Marshal(jsonNumbers(doc))
+}
+
+// jsonNumbers replaces the numbers in a JSON value with an int64, or a
+// float64 if they are not integers, as they would be unmarshalled from YAML.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []interface{}:
+		for n, e := range v {
+			v[n] = jsonNumbers(e)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// position returns the line and column of the byte at offset in content,
+// both starting at 1.
+func position(content []byte, offset int64) (int, int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// tomlToYAML converts a TOML document to YAML.
+func tomlToYAML(content []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(content), &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
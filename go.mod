@@ -2,4 +2,8 @@ module github.com/tpology/core
 
 go 1.17
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require (
+	github.com/BurntSushi/toml v1.3.2
+	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,526 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Node types of a Graph.
+const (
+	NodeResource   = "resource"
+	NodeOutput     = "output"
+	NodeTemplate   = "template"
+	NodeRepository = "repository"
+	NodeGenerator  = "generator"
+	NodeAggregate  = "aggregate"
+)
+
+// Edge types of a Graph. An edge goes from a node to a node that is affected
+// when it changes.
+const (
+	// EdgeOwns goes from a resource to the outputs it declares, or that a
+	// generator generates for it, and from a generator or aggregate to its
+	// outputs.
+	EdgeOwns = "owns"
+	// EdgeSelects goes from a resource to the outputs of the aggregates that
+	// select it.
+	EdgeSelects = "selects"
+	// EdgeReads goes from a resource to the outputs whose templates refer to
+	// it through the Resources of the context.
+	EdgeReads = "reads"
+	// EdgeDependsOn goes from a resource to the resources that declare they
+	// depend on it.
+	EdgeDependsOn = "dependsOn"
+	// EdgeReferences goes from a resource to the resources whose data refer
+	// to it with a `$ref`.
+	EdgeReferences = "references"
+	// EdgeIncludes goes from a template to the templates that include it as
+	// a partial or use it as a layout.
+	EdgeIncludes = "includes"
+	// EdgeRenders goes from a template to the outputs it renders.
+	EdgeRenders = "renders"
+	// EdgeWrites goes from an output to the repository it is committed to.
+	EdgeWrites = "writes"
+)
+
+// GraphNode is a node of a Graph.
+type GraphNode struct {
+	// ID uniquely identifies the node in the graph.
+	ID string `json:"id"`
+	// Type is one of the Node types.
+	Type string `json:"type"`
+	// Name is the name of the resource, output, template, repository,
+	// generator or aggregate.
+	Name string `json:"name"`
+	// Kind is the kind of a resource, or of the resource that generated an
+	// output.
+	Kind string `json:"kind,omitempty"`
+}
+
+// GraphEdge is an edge of a Graph.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Type is one of the Edge types.
+	Type string `json:"type"`
+}
+
+// Graph is the dependency graph between the resources, outputs, templates,
+// repositories, generators and aggregates of an Index. Nodes are sorted by ID
+// and edges by their from and to IDs.
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+
+	nodes map[string]*GraphNode
+	edges map[GraphEdge]bool
+}
+
+// resourceID returns the ID of the node of a resource.
+func resourceID(kind string, name string) string {
+	return NodeResource + ":" + kind + "/" + name
+}
+
+// templateID returns the ID of the node of a template.
+func templateID(name string) string {
+	return NodeTemplate + ":" + name
+}
+
+// repositoryID returns the ID of the node of a repository.
+func repositoryID(name string) string {
+	return NodeRepository + ":" + name
+}
+
+// generatorID returns the ID of the node of a generator.
+func generatorID(name string) string {
+	return NodeGenerator + ":" + name
+}
+
+// aggregateID returns the ID of the node of an aggregate.
+func aggregateID(name string) string {
+	return NodeAggregate + ":" + name
+}
+
+// outputID returns the ID of the node of the output that generates an
+// artifact.
+func outputID(a *Artifact) string {
+	switch {
+	case a.Aggregate != "":
+		return NodeOutput + ":aggregate/" + a.Aggregate + "/" + a.Output
+	case a.Generator != "":
+		return NodeOutput + ":generator/" + a.Generator + "/" + a.Kind + "/" + qualifiedName(a.Namespace, a.Resource) + "/" + a.Output
+	}
+	return NodeOutput + ":" + a.Kind + "/" + qualifiedName(a.Namespace, a.Resource) + "/" + a.Output
+}
+
+// Graph returns the dependency graph of the Index. Templates, and the
+// templated repository and file of outputs, are analysed for the resources
+// they refer to through the Resources of the context, such as
+// `.Resources.database.orders`, and an output whose templates may refer to
+// any part of it, such as by ranging over `.Resources.database`, reads every
+// resource. Resources may declare others they depend on. Cycles in the graph
+// are returned as errors.
+func (i *Index) Graph() (*Graph, []error) {
+	errs := []error{}
+	g := &Graph{nodes: map[string]*GraphNode{}, edges: map[GraphEdge]bool{}}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			r := i.resourceByKind[kind][name]
+			g.addNode(resourceID(kind, name), NodeResource, name, kind)
+			for _, d := range r.Resource.DependsOn {
+				if dep := i.ResolveResource(r.Resource.Namespace, d); dep != nil {
+					g.addEdge(resourceID(d.Kind, qualifiedName(dep.Resource.Namespace, dep.Resource.Name)), resourceID(kind, name), EdgeDependsOn)
+				}
+			}
+			refs, _ := i.referencedResources(r)
+			for _, ref := range refs {
+				g.addEdge(resourceID(ref.Resource.Kind, qualifiedName(ref.Resource.Namespace, ref.Resource.Name)), resourceID(kind, name), EdgeReferences)
+			}
+		}
+	}
+	for _, name := range sortedTemplateNames(i.template) {
+		g.addNode(templateID(name), NodeTemplate, name, "")
+	}
+	for name := range i.repository {
+		g.addNode(repositoryID(name), NodeRepository, name, "")
+	}
+	for name := range i.generator {
+		g.addNode(generatorID(name), NodeGenerator, name, "")
+	}
+	for name := range i.aggregate {
+		g.addNode(aggregateID(name), NodeAggregate, name, "")
+	}
+
+	base := i.defaultContext()
+	reads := map[string][]v1.ReferenceSpec{}
+	// readsAll are the templates that may read any part of the context
+	readsAll := map[string]bool{}
+	for _, job := range i.renderJobs() {
+		a := job.artifact()
+		id := outputID(a)
+		g.addNode(id, NodeOutput, a.Output, a.Kind)
+		if job.aggregate != nil {
+			g.addEdge(aggregateID(a.Aggregate), id, EdgeOwns)
+			for _, m := range job.members {
+				g.addEdge(resourceID(m.Resource.Kind, qualifiedName(m.Resource.Namespace, m.Resource.Name)), id, EdgeSelects)
+			}
+		} else {
+			g.addEdge(resourceID(a.Kind, qualifiedName(a.Namespace, a.Resource)), id, EdgeOwns)
+		}
+		if job.generator != nil {
+			g.addEdge(generatorID(a.Generator), id, EdgeOwns)
+		}
+		// the output is rendered by its template, which is compiled from
+		// its layouts and partials
+		// as is its repository and file, which may read resources too
+		refs, all := outputRefs(job.output)
+		template := i.templateName(job.namespace(), job.output.Template)
+		if _, err := i.parsedTemplate(template); err == nil {
+			g.addEdge(templateID(template), id, EdgeRenders)
+			for _, d := range i.templates.deps(template) {
+				if d != template {
+					g.addEdge(templateID(d), templateID(template), EdgeIncludes)
+				}
+				if _, ok := reads[d]; !ok {
+					reads[d], readsAll[d] = contextRefs(i.lookupTemplate(d))
+				}
+				refs = append(refs, reads[d]...)
+				all = all || readsAll[d]
+			}
+		}
+		for _, ref := range refs {
+			if i.GetResource(ref.Kind, ref.Name) != nil {
+				g.addEdge(resourceID(ref.Kind, ref.Name), id, EdgeReads)
+			}
+		}
+		if all {
+			// the output may read any resource, such as by ranging over
+			// those of a kind
+			for _, kind := range sortedKeys(i.resourceByKind) {
+				for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+					g.addEdge(resourceID(kind, name), id, EdgeReads)
+				}
+			}
+		}
+		data, err := job.context(i, base)
+		var repository string
+		if err == nil {
+			repository, err = renderString("repository", job.output.Repository, data)
+		}
+		if err != nil {
+			errs = append(errs, job.error(err))
+		} else if repository != "" {
+			repository = i.repositoryName(job.namespace(), repository)
+			g.addNode(repositoryID(repository), NodeRepository, repository, "")
+			g.addEdge(id, repositoryID(repository), EdgeWrites)
+		}
+	}
+	g.sort()
+	return g, append(errs, g.cycles()...)
+}
+
+// addNode adds a node to the graph, if it is not already in it.
+func (g *Graph) addNode(id string, typ string, name string, kind string) {
+	if _, ok := g.nodes[id]; ok {
+		return
+	}
+	n := &GraphNode{ID: id, Type: typ, Name: name, Kind: kind}
+	g.nodes[id] = n
+	g.Nodes = append(g.Nodes, n)
+}
+
+// addEdge adds an edge to the graph, if it is not already in it.
+func (g *Graph) addEdge(from string, to string, typ string) {
+	e := GraphEdge{From: from, To: to, Type: typ}
+	if g.edges[e] {
+		return
+	}
+	g.edges[e] = true
+	g.Edges = append(g.Edges, &e)
+}
+
+// sort sorts the nodes and edges of the graph.
+func (g *Graph) sort() {
+	sort.Slice(g.Nodes, func(a, b int) bool {
+		return g.Nodes[a].ID < g.Nodes[b].ID
+	})
+	sort.Slice(g.Edges, func(a, b int) bool {
+		ea, eb := g.Edges[a], g.Edges[b]
+		if ea.From != eb.From {
+			return ea.From < eb.From
+		}
+		if ea.To != eb.To {
+			return ea.To < eb.To
+		}
+		return ea.Type < eb.Type
+	})
+}
+
+// Downstream returns the nodes with the given IDs and every node reachable
+// from them, sorted by ID. IDs that are not in the graph are ignored.
+func (g *Graph) Downstream(ids []string) []*GraphNode {
+	out := map[string][]string{}
+	for _, e := range g.Edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+	nodes := map[string]bool{}
+	for _, n := range g.Nodes {
+		nodes[n.ID] = true
+	}
+	seen := map[string]bool{}
+	queue := []string{}
+	for _, id := range ids {
+		if nodes[id] && !seen[id] {
+			seen[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, to := range out[id] {
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	downstream := []*GraphNode{}
+	for _, n := range g.Nodes {
+		if seen[n.ID] {
+			downstream = append(downstream, n)
+		}
+	}
+	return downstream
+}
+
+// cycles returns an error for each cycle in the graph.
+func (g *Graph) cycles() []error {
+	errs := []error{}
+	out := map[string][]string{}
+	for _, e := range g.Edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+	// visiting is true while a node is on the current path, and false once
+	// every node reachable from it has been visited
+	visiting := map[string]bool{}
+	path := []string{}
+	var visit func(id string)
+	visit = func(id string) {
+		if v, ok := visiting[id]; ok {
+			if v {
+				// the cycle is the part of the path from the first visit
+				for n := range path {
+					if path[n] == id {
+						cycle := append(append([]string{}, path[n:]...), id)
+						errs = append(errs, fmt.Errorf("dependency cycle %s", strings.Join(cycle, " -> ")))
+						break
+					}
+				}
+			}
+			return
+		}
+		visiting[id] = true
+		path = append(path, id)
+		for _, to := range out[id] {
+			visit(to)
+		}
+		path = path[:len(path)-1]
+		visiting[id] = false
+	}
+	for _, n := range g.Nodes {
+		visit(n.ID)
+	}
+	return errs
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	shapes := map[string]string{
+		NodeResource:   "box",
+		NodeOutput:     "note",
+		NodeTemplate:   "ellipse",
+		NodeRepository: "cylinder",
+		NodeGenerator:  "hexagon",
+		NodeAggregate:  "hexagon",
+	}
+	b := strings.Builder{}
+	b.WriteString("digraph tpology {\n")
+	for _, n := range g.Nodes {
+		label := n.Name
+		if n.Type == NodeResource {
+			label = n.Kind + "/" + n.Name
+		}
+		fmt.Fprintf(&b, "  %q [label=%q shape=%s];\n", n.ID, label, shapes[n.Type])
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", e.From, e.To, e.Type)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// contextRefs returns the resources a template refers to through the
+// Resources of the context, such as `.Resources.database.orders` or
+// `${Resources.database.orders.data.port}`. all is true if the template also
+// refers to the context in a way that can not be tied to specific resources,
+// such as ranging over `.Resources` or reading `.Repositories`, in which case
+// it may depend on any part of it.
+func contextRefs(spec *v1.TemplateSpec) (refs []v1.ReferenceSpec, all bool) {
+	refs = []v1.ReferenceSpec{}
+	add := func(path []string) {
+		refs, all = appendContextRef(refs, all, path)
+	}
+	if spec == nil {
+		return refs, false
+	}
+	if !walkTemplateContext(spec, add) {
+		all = true
+	}
+	return refs, all
+}
+
+// outputRefs returns the resources the templated repository and file of the
+// output refer to through the Resources of the context, and whether they
+// refer to it in a way that can not be tied to specific resources, as
+// contextRefs does for templates.
+func outputRefs(o *v1.OutputSpec) (refs []v1.ReferenceSpec, all bool) {
+	refs = []v1.ReferenceSpec{}
+	for _, s := range []string{o.Repository, o.File} {
+		t, err := parseString("output", s)
+		if err != nil || t == nil {
+			continue
+		}
+		walkContext(t.Tree.Root, true, func(path []string) {
+			refs, all = appendContextRef(refs, all, path)
+		})
+	}
+	return refs, all
+}
+
+// appendContextRef appends the resource the path of a reference to the
+// context refers to, if it is one of Resources, to refs, and returns refs
+// and whether the path may refer to any part of the context, or all if it
+// already was true. References to Self and Selected are ignored.
+func appendContextRef(refs []v1.ReferenceSpec, all bool, path []string) ([]v1.ReferenceSpec, bool) {
+	switch {
+	case len(path) > 0 && (path[0] == "Self" || path[0] == "self" || path[0] == "Selected" || path[0] == "selected"):
+	case len(path) >= 3 && (path[0] == "Resources" || path[0] == "resources"):
+		refs = append(refs, v1.ReferenceSpec{Kind: path[1], Name: path[2]})
+	default:
+		all = true
+	}
+	return refs, all
+}
+
+// walkTemplateContext calls fn with the path of every reference to the
+// context in the template, such as `.Resources.database` or
+// `${Self.data.port}`. It returns false if the template is of an engine
+// that can not be analysed. Templates that do not parse have no references.
+func walkTemplateContext(spec *v1.TemplateSpec, fn func(path []string)) bool {
+	switch spec.Engine {
+	case "", "text", "html":
+		p, err := parseSpec(spec)
+		if err != nil {
+			return true
+		}
+		for _, tree := range p.trees {
+			walkContext(tree.Root, true, fn)
+		}
+	case "envsubst":
+		t, err := parseEnvsubst(spec.Name, spec.Content)
+		if err != nil {
+			return true
+		}
+		for _, p := range t.parts {
+			if p.path != nil {
+				fn(p.path)
+			}
+		}
+	default:
+		// the templates of other engines can not be analysed
+		return false
+	}
+	return true
+}
+
+// walkContext calls fn with the path of every reference to the context below
+// node, such as `.Resources.database` or `$.Self`. root is true if the dot is
+// the context at node, which it no longer is inside range and with. The dot
+// passed to a template or include refers to the context in the template it
+// is passed to, which is analysed on its own.
+func walkContext(node parse.Node, root bool, fn func(path []string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkContext(c, root, fn)
+		}
+	case *parse.ActionNode:
+		walkContext(n.Pipe, root, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkContext(c, root, fn)
+		}
+	case *parse.CommandNode:
+		for a, arg := range n.Args {
+			if a == 2 && includedName(n) != "" && isContext(arg) {
+				continue
+			}
+			walkContext(arg, root, fn)
+		}
+	case *parse.ChainNode:
+		walkContext(n.Node, root, fn)
+	case *parse.FieldNode:
+		if root {
+			fn(n.Ident)
+		}
+	case *parse.DotNode:
+		if root {
+			fn(nil)
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			fn(n.Ident[1:])
+		}
+	case *parse.IfNode:
+		walkContext(n.Pipe, root, fn)
+		walkContext(n.List, root, fn)
+		walkContext(n.ElseList, root, fn)
+	case *parse.RangeNode:
+		walkContext(n.Pipe, root, fn)
+		walkContext(n.List, false, fn)
+		walkContext(n.ElseList, root, fn)
+	case *parse.WithNode:
+		walkContext(n.Pipe, root, fn)
+		walkContext(n.List, false, fn)
+		walkContext(n.ElseList, root, fn)
+	case *parse.TemplateNode:
+		if n.Pipe != nil && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 && isContext(n.Pipe.Cmds[0].Args[0]) {
+			return
+		}
+		walkContext(n.Pipe, root, fn)
+	}
+}
+
+// isContext returns true if node is the dot or `$`.
+func isContext(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return true
+	case *parse.VariableNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "$"
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_Graph tests the Graph function of the Index. It expects edges
+// for owned outputs, templates, repositories, resources read by templates and
+// declared dependencies.
+func Test_Index_Graph(t *testing.T) {
+	i := mustLoad(t, "testdata/043-graph")
+	g, errs := i.Graph()
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := []GraphEdge{
+		{From: "output:service/orders/config", To: "repository:repo-1", Type: EdgeWrites},
+		{From: "resource:database/orders", To: "output:service/orders/config", Type: EdgeReads},
+		{From: "resource:queue/orders", To: "resource:service/orders", Type: EdgeDependsOn},
+		{From: "resource:service/orders", To: "output:service/orders/config", Type: EdgeOwns},
+		{From: "template:service", To: "output:service/orders/config", Type: EdgeRenders},
+	}
+	if len(g.Edges) != len(expected) {
+		t.Fatalf("Expected %d edges, got %d", len(expected), len(g.Edges))
+	}
+	for n, e := range g.Edges {
+		if *e != expected[n] {
+			t.Errorf("Expected %v, got %v", expected[n], *e)
+		}
+	}
+	if len(g.Nodes) != 6 {
+		t.Errorf("Expected 6 nodes, got %d", len(g.Nodes))
+	}
+	buf := bytes.Buffer{}
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if !strings.Contains(buf.String(), `"resource:queue/orders" -> "resource:service/orders" [label="dependsOn"];`) {
+		t.Errorf("Expected dependsOn edge in DOT output, got %s", buf.String())
+	}
+}
+
+// Test_Index_Graph_WholeContext tests the Graph function of the Index. It
+// expects an output whose template ranges over the resources of the context
+// to read every resource.
+func Test_Index_Graph_WholeContext(t *testing.T) {
+	g, errs := mustLoad(t, "testdata/066-graph-whole-context").Graph()
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := []GraphEdge{
+		{From: "output:service/orders/config", To: "repository:repo-1", Type: EdgeWrites},
+		{From: "resource:database/orders", To: "output:service/orders/config", Type: EdgeReads},
+		{From: "resource:database/users", To: "output:service/orders/config", Type: EdgeReads},
+		{From: "resource:service/orders", To: "output:service/orders/config", Type: EdgeOwns},
+		{From: "resource:service/orders", To: "output:service/orders/config", Type: EdgeReads},
+		{From: "template:service", To: "output:service/orders/config", Type: EdgeRenders},
+	}
+	if len(g.Edges) != len(expected) {
+		t.Fatalf("Expected %d edges, got %v", len(expected), g.Edges)
+	}
+	for n, e := range g.Edges {
+		if *e != expected[n] {
+			t.Errorf("Expected %v, got %v", expected[n], *e)
+		}
+	}
+}
+
+// Test_Index_Graph_Cycle tests the Graph function of the Index. It expects an
+// error for resources that depend on each other.
+func Test_Index_Graph_Cycle(t *testing.T) {
+	i := NewIndex()
+	for _, names := range [][2]string{{"a", "b"}, {"b", "a"}} {
+		i.AddResource(&v1.Resource{
+			APIVersion: "v1",
+			Resource: v1.ResourceSpec{
+				Name:      names[0],
+				Kind:      "test",
+				DependsOn: []v1.ReferenceSpec{{Kind: "test", Name: names[1]}},
+			},
+		})
+	}
+	_, errs := i.Graph()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if errs[0].Error() != "dependency cycle resource:test/a -> resource:test/b -> resource:test/a" {
+		t.Errorf("Expected dependency cycle resource:test/a -> resource:test/b -> resource:test/a, got %s", errs[0])
+	}
+}
+
+// Test_Index_Graph_References tests the Graph function of the Index. It
+// expects edges from the resources referred to in the data of a resource to
+// that resource.
+func Test_Index_Graph_References(t *testing.T) {
+	i := mustLoad(t, "testdata/054-references")
+	g, errs := i.Graph()
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := map[GraphEdge]bool{
+		{From: "resource:database/orders", To: "resource:service/orders", Type: EdgeReferences}: true,
+		{From: "resource:queue/orders", To: "resource:service/orders", Type: EdgeReferences}:    true,
+	}
+	for _, e := range g.Edges {
+		delete(expected, *e)
+	}
+	if len(expected) != 0 {
+		t.Errorf("Expected edges %v, got %v", expected, g.Edges)
+	}
+}
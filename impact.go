@@ -0,0 +1,84 @@
+package core
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Impact is the set of documents and outputs affected by a change to some
+// files of the model. Each list of nodes is sorted by ID.
+type Impact struct {
+	// Files are the changed files that documents were loaded from.
+	Files []string `json:"files"`
+	// Unknown are the changed files that no document was loaded from, such
+	// as new or deleted files. A caller can not know what they affect without
+	// loading the model again.
+	Unknown []string `json:"unknown"`
+	// Resources are the resources loaded from the files, and those that
+	// depend on them.
+	Resources []*GraphNode `json:"resources"`
+	// Templates are the templates loaded from the files, and those that
+	// include them.
+	Templates []*GraphNode `json:"templates"`
+	// Generators are the generators loaded from the files.
+	Generators []*GraphNode `json:"generators"`
+	// Aggregates are the aggregates loaded from the files.
+	Aggregates []*GraphNode `json:"aggregates"`
+	// Outputs are the outputs that must be rendered again.
+	Outputs []*GraphNode `json:"outputs"`
+	// Repositories are the repositories loaded from the files, and those
+	// that affected outputs are committed to.
+	Repositories []*GraphNode `json:"repositories"`
+}
+
+// Affected returns the impact of a change to the files, which are given as
+// paths in the same form as the documents were loaded, for example joined
+// with the directory passed to Load. Errors building the dependency graph
+// are returned along with the impact.
+func (i *Index) Affected(files []string) (*Impact, []error) {
+	g, errs := i.Graph()
+	impact := &Impact{
+		Files:        []string{},
+		Unknown:      []string{},
+		Resources:    []*GraphNode{},
+		Templates:    []*GraphNode{},
+		Generators:   []*GraphNode{},
+		Aggregates:   []*GraphNode{},
+		Outputs:      []*GraphNode{},
+		Repositories: []*GraphNode{},
+	}
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, f := range files {
+		f = filepath.Clean(f)
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if docs := i.documentsFrom(f); len(docs) > 0 {
+			impact.Files = append(impact.Files, f)
+			ids = append(ids, docs...)
+		} else {
+			impact.Unknown = append(impact.Unknown, f)
+		}
+	}
+	sort.Strings(impact.Files)
+	sort.Strings(impact.Unknown)
+	for _, n := range g.Downstream(ids) {
+		switch n.Type {
+		case NodeResource:
+			impact.Resources = append(impact.Resources, n)
+		case NodeTemplate:
+			impact.Templates = append(impact.Templates, n)
+		case NodeGenerator:
+			impact.Generators = append(impact.Generators, n)
+		case NodeAggregate:
+			impact.Aggregates = append(impact.Aggregates, n)
+		case NodeOutput:
+			impact.Outputs = append(impact.Outputs, n)
+		case NodeRepository:
+			impact.Repositories = append(impact.Repositories, n)
+		}
+	}
+	return impact, errs
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// nodeIDs is a helper function that returns the IDs of nodes.
+func nodeIDs(nodes []*GraphNode) []string {
+	s := []string{}
+	for _, n := range nodes {
+		s = append(s, n.ID)
+	}
+	return s
+}
+
+// Test_Index_Affected tests the Affected function of the Index. It expects
+// the resources, templates, outputs and repositories downstream of the
+// documents loaded from the changed files, and files that no document was
+// loaded from to be reported as unknown.
+func Test_Index_Affected(t *testing.T) {
+	dir := "testdata/043-graph"
+	i := mustLoad(t, dir)
+	tests := []struct {
+		files        []string
+		resources    []string
+		templates    []string
+		outputs      []string
+		repositories []string
+		unknown      []string
+	}{
+		{
+			files:        []string{"template-1.yaml"},
+			resources:    []string{},
+			templates:    []string{"template:service"},
+			outputs:      []string{"output:service/orders/config"},
+			repositories: []string{"repository:repo-1"},
+			unknown:      []string{},
+		},
+		{
+			files:        []string{"queue-1.yaml", "missing.yaml"},
+			resources:    []string{"resource:queue/orders", "resource:service/orders"},
+			templates:    []string{},
+			outputs:      []string{"output:service/orders/config"},
+			repositories: []string{"repository:repo-1"},
+			unknown:      []string{filepath.Join(dir, "missing.yaml")},
+		},
+		{
+			files:        []string{"repository-1.yaml"},
+			resources:    []string{},
+			templates:    []string{},
+			outputs:      []string{},
+			repositories: []string{"repository:repo-1"},
+			unknown:      []string{},
+		},
+	}
+	for _, test := range tests {
+		files := []string{}
+		for _, f := range test.files {
+			files = append(files, filepath.Join(dir, f))
+		}
+		impact, errs := i.Affected(files)
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		for _, c := range []struct {
+			name     string
+			expected []string
+			got      []string
+		}{
+			{"resources", test.resources, nodeIDs(impact.Resources)},
+			{"templates", test.templates, nodeIDs(impact.Templates)},
+			{"outputs", test.outputs, nodeIDs(impact.Outputs)},
+			{"repositories", test.repositories, nodeIDs(impact.Repositories)},
+			{"unknown", test.unknown, impact.Unknown},
+		} {
+			if len(c.got) != len(c.expected) {
+				t.Errorf("Expected %s %v for %v, got %v", c.name, c.expected, test.files, c.got)
+				continue
+			}
+			for n := range c.got {
+				if c.got[n] != c.expected[n] {
+					t.Errorf("Expected %s %v for %v, got %v", c.name, c.expected, test.files, c.got)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Test_Index_Affected_WholeContext tests the Affected function of the Index.
+// It expects a change to a resource read only by ranging over the resources
+// of its kind to affect the output of the template, and its repository.
+func Test_Index_Affected_WholeContext(t *testing.T) {
+	dir := "testdata/066-graph-whole-context"
+	impact, errs := mustLoad(t, dir).Affected([]string{filepath.Join(dir, "database-2.yaml")})
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if outputs := nodeIDs(impact.Outputs); len(outputs) != 1 || outputs[0] != "output:service/orders/config" {
+		t.Errorf("Expected output:service/orders/config, got %v", outputs)
+	}
+	if repositories := nodeIDs(impact.Repositories); len(repositories) != 1 || repositories[0] != "repository:repo-1" {
+		t.Errorf("Expected repository:repo-1, got %v", repositories)
+	}
+}
+
+// Test_Index_Affected_Removed tests the Affected function of the Index. It
+// expects a file to be unknown once the documents loaded from it are removed.
+func Test_Index_Affected_Removed(t *testing.T) {
+	i := mustLoad(t, "testdata/043-graph")
+	i.RemoveTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: "service"}})
+	impact, _ := i.Affected([]string{"testdata/043-graph/template-1.yaml"})
+	if len(impact.Unknown) != 1 || len(impact.Outputs) != 0 {
+		t.Errorf("Expected 1 unknown file and 0 outputs, got %v and %v", impact.Unknown, nodeIDs(impact.Outputs))
+	}
+}
@@ -3,18 +3,44 @@ package core
 import (
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	v1 "github.com/tpology/core/api/v1"
 	"gopkg.in/yaml.v2"
 )
 
-// Index is the index of all resources
+// Index is the index of all resources. Resources are keyed by kind and
+// qualified name, and templates and repositories by qualified name, where the
+// qualified name of a document in a namespace is `namespace/name`.
 type Index struct {
 	resourceByKind map[string]map[string]*v1.Resource
 	template       map[string]*v1.Template
 	repository     map[string]*v1.Repository
+	generator      map[string]*v1.Generator
+	aggregate      map[string]*v1.Aggregate
+	policy         map[string]*v1.Policy
+	templates      *templateCache
+	// sources is where each document was loaded from, keyed by graph node
+	// ID. A template loaded from a file has the source of its document
+	// followed by that of the file.
+	sources map[string][]v1.SourceSpec
+	// encrypted are the ciphertexts of the values decrypted in the data of
+	// each resource, keyed by path, such as `data.db.password`.
+	encrypted map[*v1.Resource]map[string]string
+	// derived are the resources with computed fields whose value is derived
+	// from decrypted values, as set by Compute.
+	derived map[*v1.Resource]bool
+	// Strict promotes the warnings of Load, LoadFS, Import and Validate to
+	// errors, such as in CI, so that rules rolled out as warnings can be
+	// enforced.
+	Strict bool
+	// DecryptionKey is the key the values of the data of resources encrypted
+	// by EncryptValues are decrypted with when the Index is validated. They
+	// are left encrypted without it, and reported as warnings. Export keeps
+	// them encrypted either way.
+	DecryptionKey []byte
 }
 
 // NewIndex returns a new Index
@@ -23,215 +49,560 @@ func NewIndex() *Index {
 		resourceByKind: map[string]map[string]*v1.Resource{},
 		template:       map[string]*v1.Template{},
 		repository:     map[string]*v1.Repository{},
+		generator:      map[string]*v1.Generator{},
+		aggregate:      map[string]*v1.Aggregate{},
+		policy:         map[string]*v1.Policy{},
+		templates:      newTemplateCache(),
+		sources:        map[string][]v1.SourceSpec{},
+		encrypted:      map[*v1.Resource]map[string]string{},
+		derived:        map[*v1.Resource]bool{},
 	}
 }
 
+// qualifiedName returns the name of a document in namespace, qualified by the
+// namespace unless it is the default namespace.
+func qualifiedName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
 // AddResource adds a resource to the index
 func (i *Index) AddResource(r *v1.Resource) error {
+	name := qualifiedName(r.Resource.Namespace, r.Resource.Name)
 	if _, ok := i.resourceByKind[r.Resource.Kind]; !ok {
 		i.resourceByKind[r.Resource.Kind] = map[string]*v1.Resource{}
 	}
-	if _, ok := i.resourceByKind[r.Resource.Kind][r.Resource.Name]; ok {
-		return fmt.Errorf("resource %s of kind %s already exists", r.Resource.Name, r.Resource.Kind)
+	if _, ok := i.resourceByKind[r.Resource.Kind][name]; ok {
+		return fmt.Errorf("resource %s of kind %s already exists", name, r.Resource.Kind)
 	}
-	i.resourceByKind[r.Resource.Kind][r.Resource.Name] = r
+	i.resourceByKind[r.Resource.Kind][name] = r
 	return nil
 }
 
 // RemoveResource removes a resource from the index
 func (i *Index) RemoveResource(r *v1.Resource) error {
+	name := qualifiedName(r.Resource.Namespace, r.Resource.Name)
 	if _, ok := i.resourceByKind[r.Resource.Kind]; ok {
-		delete(i.resourceByKind[r.Resource.Kind], r.Resource.Name)
+		i.forgetSources(resourceID(r.Resource.Kind, name))
+		delete(i.encrypted, i.resourceByKind[r.Resource.Kind][name])
+		delete(i.derived, i.resourceByKind[r.Resource.Kind][name])
+		delete(i.resourceByKind[r.Resource.Kind], name)
 		if len(i.resourceByKind[r.Resource.Kind]) == 0 {
 			delete(i.resourceByKind, r.Resource.Kind)
 		}
 		return nil
 	}
-	return fmt.Errorf("resource %s of kind %s does not exist", r.Resource.Name, r.Resource.Kind)
+	return fmt.Errorf("resource %s of kind %s does not exist", name, r.Resource.Kind)
 }
 
 // AddTemplate adds a template to the index
 func (i *Index) AddTemplate(t *v1.Template) error {
-	if _, ok := i.template[t.Template.Name]; ok {
-		return fmt.Errorf("template %s already exists", t.Template.Name)
+	name := qualifiedName(t.Template.Namespace, t.Template.Name)
+	if _, ok := i.template[name]; ok {
+		return fmt.Errorf("template %s already exists", name)
+	}
+	i.template[name] = t
+	i.templates.invalidate(name)
+	// templates of the namespace referring to the template by its name
+	// may have been compiled with the template of the default namespace
+	if t.Template.Namespace != "" {
+		i.templates.invalidate(t.Template.Name)
 	}
-	i.template[t.Template.Name] = t
 	return nil
 }
 
 // RemoveTemplate removes a template from the index
 func (i *Index) RemoveTemplate(t *v1.Template) error {
-	if _, ok := i.template[t.Template.Name]; ok {
-		delete(i.template, t.Template.Name)
+	name := qualifiedName(t.Template.Namespace, t.Template.Name)
+	if _, ok := i.template[name]; ok {
+		i.forgetSources(templateID(name))
+		delete(i.template, name)
+		i.templates.invalidate(name)
 		return nil
 	}
-	return fmt.Errorf("template %s does not exist", t.Template.Name)
+	return fmt.Errorf("template %s does not exist", name)
+}
+
+// templateName returns the qualified name of the template an output of a
+// document in namespace refers to by name. A name that is not qualified is
+// looked up in the namespace, and then in the default namespace.
+func (i *Index) templateName(namespace string, name string) string {
+	return templateRef(namespace, name, i.lookupTemplate)
+}
+
+// parsedTemplate returns the named template compiled with its layouts and
+// partials, using the cache of compiled templates.
+func (i *Index) parsedTemplate(name string) (Executable, error) {
+	return i.templates.get(name, i.lookupTemplate)
+}
+
+// strictTemplate returns the named template compiled like parsedTemplate,
+// failing when it reads a key missing from a map of the context.
+func (i *Index) strictTemplate(name string) (Executable, error) {
+	return i.templates.getStrict(name, i.lookupTemplate)
+}
+
+// lookupTemplate returns the named template spec, or nil if there is none.
+func (i *Index) lookupTemplate(name string) *v1.TemplateSpec {
+	if t, ok := i.template[name]; ok {
+		return &t.Template
+	}
+	return nil
 }
 
 // AddRepository adds a repository to the index
 func (i *Index) AddRepository(r *v1.Repository) error {
-	if _, ok := i.repository[r.Repository.Name]; ok {
-		return fmt.Errorf("repository %s already exists", r.Repository.Name)
+	name := qualifiedName(r.Repository.Namespace, r.Repository.Name)
+	if _, ok := i.repository[name]; ok {
+		return fmt.Errorf("repository %s already exists", name)
 	}
-	i.repository[r.Repository.Name] = r
+	i.repository[name] = r
 	return nil
 }
 
 // RemoveRepository removes a repository from the index
 func (i *Index) RemoveRepository(r *v1.Repository) error {
-	if _, ok := i.repository[r.Repository.Name]; ok {
-		delete(i.repository, r.Repository.Name)
+	name := qualifiedName(r.Repository.Namespace, r.Repository.Name)
+	if _, ok := i.repository[name]; ok {
+		i.forgetSources(repositoryID(name))
+		delete(i.repository, name)
+		return nil
+	}
+	return fmt.Errorf("repository %s does not exist", name)
+}
+
+// repositoryName returns the qualified name of the repository an output of a
+// document in namespace refers to by name, in the same way as templateName.
+func (i *Index) repositoryName(namespace string, name string) string {
+	if namespace != "" && !strings.Contains(name, "/") {
+		if _, ok := i.repository[qualifiedName(namespace, name)]; ok {
+			return qualifiedName(namespace, name)
+		}
+	}
+	return name
+}
+
+// AddGenerator adds a generator to the index
+func (i *Index) AddGenerator(g *v1.Generator) error {
+	if _, ok := i.generator[g.Generator.Name]; ok {
+		return fmt.Errorf("generator %s already exists", g.Generator.Name)
+	}
+	i.generator[g.Generator.Name] = g
+	return nil
+}
+
+// RemoveGenerator removes a generator from the index
+func (i *Index) RemoveGenerator(g *v1.Generator) error {
+	if _, ok := i.generator[g.Generator.Name]; ok {
+		i.forgetSources(generatorID(g.Generator.Name))
+		delete(i.generator, g.Generator.Name)
+		return nil
+	}
+	return fmt.Errorf("generator %s does not exist", g.Generator.Name)
+}
+
+// AddAggregate adds an aggregate to the index
+func (i *Index) AddAggregate(a *v1.Aggregate) error {
+	if _, ok := i.aggregate[a.Aggregate.Name]; ok {
+		return fmt.Errorf("aggregate %s already exists", a.Aggregate.Name)
+	}
+	i.aggregate[a.Aggregate.Name] = a
+	return nil
+}
+
+// RemoveAggregate removes an aggregate from the index
+func (i *Index) RemoveAggregate(a *v1.Aggregate) error {
+	if _, ok := i.aggregate[a.Aggregate.Name]; ok {
+		i.forgetSources(aggregateID(a.Aggregate.Name))
+		delete(i.aggregate, a.Aggregate.Name)
 		return nil
 	}
-	return fmt.Errorf("repository %s does not exist", r.Repository.Name)
+	return fmt.Errorf("aggregate %s does not exist", a.Aggregate.Name)
+}
+
+// AddPolicy adds a policy to the index
+func (i *Index) AddPolicy(p *v1.Policy) error {
+	if _, ok := i.policy[p.Policy.Name]; ok {
+		return fmt.Errorf("policy %s already exists", p.Policy.Name)
+	}
+	i.policy[p.Policy.Name] = p
+	return nil
 }
 
-func (i *Index) Load(dir string) []error {
+// RemovePolicy removes a policy from the index
+func (i *Index) RemovePolicy(p *v1.Policy) error {
+	if _, ok := i.policy[p.Policy.Name]; ok {
+		i.forgetSources(policyID(p.Policy.Name))
+		delete(i.policy, p.Policy.Name)
+		return nil
+	}
+	return fmt.Errorf("policy %s does not exist", p.Policy.Name)
+}
+
+// AggregateMembers returns the resources selected by the aggregate, sorted by
+// kind and name.
+func (i *Index) AggregateMembers(a *v1.Aggregate) []*v1.Resource {
+	members := []*v1.Resource{}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			r := i.resourceByKind[kind][name]
+			if a.Aggregate.Matches(&r.Resource) {
+				members = append(members, r)
+			}
+		}
+	}
+	return members
+}
+
+// AggregatesSelecting returns the aggregates that select the resource, sorted
+// by name. Their outputs must be rendered again whenever the resource is
+// added, changed or removed.
+func (i *Index) AggregatesSelecting(r *v1.Resource) []*v1.Aggregate {
+	selecting := []*v1.Aggregate{}
+	for _, name := range sortedAggregateNames(i.aggregate) {
+		if i.aggregate[name].Aggregate.Matches(&r.Resource) {
+			selecting = append(selecting, i.aggregate[name])
+		}
+	}
+	return selecting
+}
+
+// GetResource returns the resource of the given kind and qualified name, or
+// nil if there is none.
+func (i *Index) GetResource(kind string, name string) *v1.Resource {
+	return i.resourceByKind[kind][name]
+}
+
+// ResolveResource returns the resource a reference from a document in
+// namespace refers to, or nil if there is none. A reference with no
+// namespace refers to the resource in namespace if there is one, and
+// otherwise to the resource in the default namespace.
+func (i *Index) ResolveResource(namespace string, ref v1.ReferenceSpec) *v1.Resource {
+	if ref.Namespace != "" {
+		return i.GetResource(ref.Kind, qualifiedName(ref.Namespace, ref.Name))
+	}
+	if r := i.GetResource(ref.Kind, qualifiedName(namespace, ref.Name)); r != nil {
+		return r
+	}
+	return i.GetResource(ref.Kind, ref.Name)
+}
+
+// Namespaces returns the namespaces of the resources, templates and
+// repositories of the Index in sorted order, not including the default
+// namespace.
+func (i *Index) Namespaces() []string {
+	seen := map[string]bool{}
+	for _, resources := range i.resourceByKind {
+		for _, r := range resources {
+			seen[r.Resource.Namespace] = true
+		}
+	}
+	for _, t := range i.template {
+		seen[t.Template.Namespace] = true
+	}
+	for _, r := range i.repository {
+		seen[r.Repository.Namespace] = true
+	}
+	delete(seen, "")
+	namespaces := make([]string, 0, len(seen))
+	for ns := range seen {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// SelectResources returns the resources matching the selector, sorted by
+// name.
+func (i *Index) SelectResources(s *v1.SelectorSpec) []*v1.Resource {
+	selected := []*v1.Resource{}
+	for _, name := range sortedResourceNames(i.resourceByKind[s.Kind]) {
+		r := i.resourceByKind[s.Kind][name]
+		if s.Matches(&r.Resource) {
+			selected = append(selected, r)
+		}
+	}
+	return selected
+}
+
+// Sources returns where the document with the graph node ID, such as
+// `resource:kind/name` or `template:name`, was loaded from. It returns nil for
+// a document that was not loaded from a file.
+func (i *Index) Sources(id string) []v1.SourceSpec {
+	return i.sources[id]
+}
+
+// addSource records that the document with the graph node ID was loaded from
+// source.
+func (i *Index) addSource(id string, source v1.SourceSpec) {
+	source.File = filepath.Clean(source.File)
+	i.sources[id] = append(i.sources[id], source)
+}
+
+// forgetSources forgets where the document with the graph node ID was loaded
+// from.
+func (i *Index) forgetSources(id string) {
+	delete(i.sources, id)
+}
+
+// documentsFrom returns the graph node IDs of the documents loaded from the
+// file at path, in sorted order.
+func (i *Index) documentsFrom(path string) []string {
+	path = filepath.Clean(path)
+	ids := []string{}
+	for id, sources := range i.sources {
+		for _, s := range sources {
+			if s.File == path {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// duplicateError returns the error of adding the document loaded from source
+// that has the same graph node ID as a document of the Index, citing where
+// that document was loaded from if it is known.
+func (i *Index) duplicateError(source v1.SourceSpec, id string, err error) error {
+	if existing := i.sources[id]; len(existing) > 0 {
+		return fmt.Errorf("%s: %s, first defined at %s", source, err, existing[0])
+	}
+	// Format the error to prepend the resource path
+	return fmt.Errorf("%s: %s", source.File, err)
+}
+
+// Load loads every document in the directory dir into the Index and then
+// validates it, returning the diagnostics of both. Documents are read from
+// YAML (.yaml or .yml), JSON (.json) and TOML (.toml) files, and may be of
+// API version v1 or v2. The Index is only valid if there is no diagnostic
+// of error severity.
+func (i *Index) Load(dir string) Diagnostics {
+	return i.load(dirSource(dir))
+}
+
+// LoadFS loads every document in the filesystem fsys into the Index and then
+// validates it, returning the diagnostics of both.
+func (i *Index) LoadFS(fsys fs.FS) Diagnostics {
+	return i.load(fsSource(fsys))
+}
+
+// load loads every document in src into the Index and then validates it.
+func (i *Index) load(src *source) Diagnostics {
 	errs := []error{}
-	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	err := src.walk(func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			errs = append(errs, err)
 			return nil
 		}
-		if d.IsDir() || (filepath.Ext(d.Name()) != ".yaml" && filepath.Ext(d.Name()) != ".yml") {
+		if d.IsDir() || !isDocument(d.Name()) {
 			return nil
 		}
-		yamlBytes, err := ioutil.ReadFile(path)
+		content, err := src.readFile(path)
 		if err != nil {
 			errs = append(errs, err)
 			return nil
 		}
-		var doc map[string]interface{}
-		err = yaml.Unmarshal(yamlBytes, &doc)
+		docs, err := readDocuments(path, content)
 		if err != nil {
 			// Format the error to prepend the resource path
 			errs = append(errs, fmt.Errorf("%s: %s", path, err))
 			return nil
 		}
-		// There must be APIVersion = v1
-		apiVersion, ok := doc["apiVersion"].(string)
-		if !ok {
-			errs = append(errs, fmt.Errorf("%s: no apiVersion", path))
-			return nil
+		for _, d := range docs {
+			errs = append(errs, i.loadDocument(src, d)...)
 		}
-		if apiVersion != "v1" {
-			errs = append(errs, fmt.Errorf("%s: invalid apiVersion", path))
-			return nil
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errorDiagnostics(errs)
+	}
+	return i.Validate()
+}
+
+// Validate decrypts the encrypted values of the data of resources, validates
+// the Index, then computes the computed fields of its resources and checks
+// its policies, which are reported with the severity of their rules. Load,
+// LoadFS and Import validate the Index once its documents are added, so
+// Validate only has to be called again when documents are added or replaced.
+func (i *Index) Validate() Diagnostics {
+	d := i.decrypt()
+	if d.HasErrors() {
+		return i.diagnostics(d)
+	}
+	if errs := i.validate(); len(errs) > 0 {
+		return i.diagnostics(append(d, errorDiagnostics(errs)...))
+	}
+	if errs := i.Compute(); len(errs) > 0 {
+		return i.diagnostics(append(d, errorDiagnostics(errs)...))
+	}
+	return i.diagnostics(append(d, i.policyDiagnostics()...))
+}
+
+// diagnostics returns d with warnings promoted to errors if the Index is
+// strict.
+func (i *Index) diagnostics(d Diagnostics) Diagnostics {
+	if i.Strict {
+		return d.Strict()
+	}
+	return d
+}
+
+// loadDocument loads a document read from src into the Index, converting
+// it from its API version.
+func (i *Index) loadDocument(src *source, d document) []error {
+	errs := []error{}
+	path, source := d.source.File, d.source
+	var doc map[string]interface{}
+	err := yaml.Unmarshal(d.content, &doc)
+	if err != nil {
+		// Format the error to prepend the resource path
+		errs = append(errs, fmt.Errorf("%s: %s", path, err))
+		return errs
+	}
+	o, verrs := decodeDocument(doc, d.content)
+	if len(verrs) > 0 {
+		// Format the errors to prepend the resource path
+		for _, err := range verrs {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
 		}
-		if _, ok := doc["resource"]; ok {
-			// If there is a resource key, unmarshal as Resource
-			verrs := validateResourceFields(doc)
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			verrs = validateResourceSpecFields(doc["resource"].(map[interface{}]interface{}))
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			output := doc["resource"].(map[interface{}]interface{})["output"]
-			if output != nil {
-				verrs = validateOutputSpecFields(doc["resource"].(map[interface{}]interface{})["output"].(map[interface{}]interface{}))
-				if len(verrs) > 0 {
-					// Format the errors to prepend the resource path
-					for _, err := range verrs {
-						errs = append(errs, fmt.Errorf("%s: %s", path, err))
-					}
-					return nil
-				}
-			}
-			var resource v1.Resource
-			err = yaml.Unmarshal(yamlBytes, &resource)
-			if err != nil {
-				// Format the error to prepend the resource path
-				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
-			}
-			err = i.AddResource(&resource)
-			if err != nil {
-				// Format the error to prepend the resource path
-				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
-			}
-		} else if _, ok := doc["template"]; ok {
-			// If there is a template key, unmarshal as Template
-			verrs := validateTemplateFields(doc)
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			verrs = validateTemplateSpecFields(doc["template"].(map[interface{}]interface{}))
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			var template v1.Template
-			err = yaml.Unmarshal(yamlBytes, &template)
-			if err != nil {
-				// Format the error to prepend the resource path
-				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
-			}
-			err = i.AddTemplate(&template)
-			if err != nil {
-				// Format the error to prepend the resource path
-				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
-			}
-		} else if _, ok := doc["repository"]; ok {
-			// If there is a repository key, unmarshal as Repository
-			verrs := validateRepositoryFields(doc)
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			verrs = validateRepositorySpecFields(doc["repository"].(map[interface{}]interface{}))
-			if len(verrs) > 0 {
-				// Format the errors to prepend the resource path
-				for _, err := range verrs {
-					errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				}
-				return nil
-			}
-			var repository v1.Repository
-			err = yaml.Unmarshal(yamlBytes, &repository)
-			if err != nil {
-				// Format the error to prepend the resource path
-				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
-			}
-			err = i.AddRepository(&repository)
+		return errs
+	}
+	var id string
+	templateFile := ""
+	switch {
+	case o.resource != nil:
+		id = resourceID(o.resource.Resource.Kind, qualifiedName(o.resource.Resource.Namespace, o.resource.Resource.Name))
+		err = i.AddResource(o.resource)
+	case o.template != nil:
+		id = templateID(qualifiedName(o.template.Template.Namespace, o.template.Template.Name))
+		if o.template.Template.File != "" {
+			templateFile, err = loadTemplateFile(src, path, o.template)
 			if err != nil {
 				// Format the error to prepend the resource path
 				errs = append(errs, fmt.Errorf("%s: %s", path, err))
-				return nil
+				return errs
 			}
-		} else {
-			errs = append(errs, fmt.Errorf("%s: no resource or template", path))
 		}
-		return nil
-	})
-	if err != nil {
-		errs = append(errs, err)
+		err = i.AddTemplate(o.template)
+	case o.repository != nil:
+		id = repositoryID(qualifiedName(o.repository.Repository.Namespace, o.repository.Repository.Name))
+		err = i.AddRepository(o.repository)
+	case o.generator != nil:
+		id = generatorID(o.generator.Generator.Name)
+		err = i.AddGenerator(o.generator)
+	case o.aggregate != nil:
+		id = aggregateID(o.aggregate.Aggregate.Name)
+		err = i.AddAggregate(o.aggregate)
+	case o.policy != nil:
+		id = policyID(o.policy.Policy.Name)
+		err = i.AddPolicy(o.policy)
 	}
-	if len(errs) > 0 {
+	if err != nil {
+		errs = append(errs, i.duplicateError(source, id, err))
 		return errs
 	}
-	return i.validate()
+	i.addSource(id, source)
+	if templateFile != "" {
+		i.addSource(id, v1.SourceSpec{
+			File:      templateFile,
+			StartLine: 1,
+			EndLine:   countLines([]byte(o.template.Template.Content)),
+		})
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of a resourceByKind map in sorted order.
+func sortedKeys(m map[string]map[string]*v1.Resource) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedTemplateNames returns the names of the templates in sorted order.
+func sortedTemplateNames(m map[string]*v1.Template) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadTemplateFile sets the content of a template to the content of its file,
+// which is relative to the document at doc. It returns the path of the file.
+func loadTemplateFile(src *source, doc string, t *v1.Template) (string, error) {
+	if t.Template.Content != "" {
+		return "", fmt.Errorf("template %s has both content and file", t.Template.Name)
+	}
+	p, err := src.resolve(doc, t.Template.File)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %s", t.Template.Name, err)
+	}
+	content, err := src.readFile(p)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %s", t.Template.Name, err)
+	}
+	t.Template.Content = string(content)
+	return p, nil
+}
+
+// sortedResourceNames returns the names of the resources in sorted order.
+func sortedResourceNames(m map[string]*v1.Resource) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedGeneratorNames returns the names of the generators in sorted order.
+func sortedGeneratorNames(m map[string]*v1.Generator) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedAggregateNames returns the names of the aggregates in sorted order.
+func sortedAggregateNames(m map[string]*v1.Aggregate) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedPolicyNames returns the names of the policies in sorted order.
+func sortedPolicyNames(m map[string]*v1.Policy) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedRepositoryNames returns the names of the repositories in sorted order.
+func sortedRepositoryNames(m map[string]*v1.Repository) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -1,8 +1,12 @@
 package core
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	v1 "github.com/tpology/core/api/v1"
 )
@@ -496,8 +500,8 @@ func Test_Index_Load_InvalidResource(t *testing.T) {
 	if len(errs) != 1 {
 		t.Errorf("Expected 1 error, got %d", len(errs))
 	}
-	if errs[0].Error() != "testdata/008-invalid-resource/resource-1.yaml: no resource or template" {
-		t.Errorf("Expected testdata/008-invalid-resource/resource-1.yaml: no resource or template, got %s", errs[0].Error())
+	if errs[0].Error() != "testdata/008-invalid-resource/resource-1.yaml: no resource, template, repository, generator, aggregate or policy" {
+		t.Errorf("Expected testdata/008-invalid-resource/resource-1.yaml: no resource, template, repository, generator, aggregate or policy, got %s", errs[0].Error())
 	}
 }
 
@@ -561,15 +565,16 @@ func Test_Index_LoadResourceWithOutput(t *testing.T) {
 }
 
 // Test_Index_Load_TwoResourceSameName tests the Load function of the Index. It
-// expects to get an error trying to load 2 resources of the same kind and name.
+// expects to get an error trying to load 2 resources of the same kind and name,
+// citing where both are defined.
 func Test_Index_Load_TwoResourceSameName(t *testing.T) {
 	i := NewIndex()
 	errs := i.Load("testdata/024-two-resources-same-name")
 	if len(errs) != 1 {
 		t.Errorf("Expected 1 error, got %d", len(errs))
 	}
-	if errs[0].Error() != "testdata/024-two-resources-same-name/resource-2.yaml: resource resource-1 of kind test already exists" {
-		t.Errorf("Expected testdata/024-two-resources-same-name/resource-2.yaml: resource resource-1 of kind test already exists, got %s", errs[0].Error())
+	if errs[0].Error() != "testdata/024-two-resources-same-name/resource-2.yaml:1-4: resource resource-1 of kind test already exists, first defined at testdata/024-two-resources-same-name/resource-1.yaml:1-4" {
+		t.Errorf("Expected testdata/024-two-resources-same-name/resource-2.yaml:1-4: resource resource-1 of kind test already exists, first defined at testdata/024-two-resources-same-name/resource-1.yaml:1-4, got %s", errs[0].Error())
 	}
 }
 
@@ -609,8 +614,8 @@ func Test_Index_Load_TwoTemplateSameName(t *testing.T) {
 	if len(errs) != 1 {
 		t.Errorf("Expected 1 error, got %d", len(errs))
 	}
-	if errs[0].Error() != "testdata/026-two-templates-same-name/template-2.yaml: template template-1 already exists" {
-		t.Errorf("Expected testdata/026-two-templates-same-name/template-2.yaml: template template-1 already exists, got %s", errs[0].Error())
+	if errs[0].Error() != "testdata/026-two-templates-same-name/template-2.yaml:1-4: template template-1 already exists, first defined at testdata/026-two-templates-same-name/template-1.yaml:1-4" {
+		t.Errorf("Expected testdata/026-two-templates-same-name/template-2.yaml:1-4: template template-1 already exists, first defined at testdata/026-two-templates-same-name/template-1.yaml:1-4, got %s", errs[0].Error())
 	}
 }
 
@@ -622,7 +627,416 @@ func Test_Index_Load_TwoRepositorySameName(t *testing.T) {
 	if len(errs) != 1 {
 		t.Errorf("Expected 1 error, got %d", len(errs))
 	}
-	if errs[0].Error() != "testdata/027-two-repositories-same-name/repository-2.yaml: repository repo-1 already exists" {
-		t.Errorf("Expected testdata/027-two-repositories-same-name/repository-2.yaml: repository repo-1 already exists, got %s", errs[0].Error())
+	if errs[0].Error() != "testdata/027-two-repositories-same-name/repository-2.yaml:1-5: repository repo-1 already exists, first defined at testdata/027-two-repositories-same-name/repository-1.yaml:1-5" {
+		t.Errorf("Expected testdata/027-two-repositories-same-name/repository-2.yaml:1-5: repository repo-1 already exists, first defined at testdata/027-two-repositories-same-name/repository-1.yaml:1-5, got %s", errs[0].Error())
+	}
+}
+
+// Test_Index_Load_TemplateFile tests the Load function of the Index. It
+// expects the content of a template to be read from its file.
+func Test_Index_Load_TemplateFile(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/034-template-file")
+	if len(errs) != 0 {
+		t.Errorf("Expected 0 errors, got %v", errs)
+	}
+	tpl := i.template["template-1"]
+	if tpl.Template.Content != "name: {{ .Self.name }}\n" {
+		t.Errorf("Expected template content from file, got %q", tpl.Template.Content)
+	}
+}
+
+// Test_Index_LoadFS_TemplateFile tests the LoadFS function of the Index. It
+// expects the content of a template to be read from its file in the
+// filesystem.
+func Test_Index_LoadFS_TemplateFile(t *testing.T) {
+	i := NewIndex()
+	errs := i.LoadFS(fstest.MapFS{
+		"model/template-1.yaml":     &fstest.MapFile{Data: []byte("apiVersion: v1\ntemplate:\n  name: template-1\n  file: ../templates/template-1.tmpl\n")},
+		"templates/template-1.tmpl": &fstest.MapFile{Data: []byte("test")},
+	})
+	if len(errs) != 0 {
+		t.Errorf("Expected 0 errors, got %v", errs)
+	}
+	tpl := i.template["template-1"]
+	if tpl.Template.Content != "test" {
+		t.Errorf("Expected test, got %q", tpl.Template.Content)
+	}
+}
+
+// Test_Index_Load_TemplateFileOutsideRoot tests the Load function of the
+// Index. It expects an error for a template file outside of the loaded
+// directory.
+func Test_Index_Load_TemplateFileOutsideRoot(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/035-template-file-outside-root/model")
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "testdata/035-template-file-outside-root/model/template-1.yaml: template template-1: file ../outside.tmpl is outside of testdata/035-template-file-outside-root/model" {
+		t.Errorf("Expected file outside error, got %s", errs[0].Error())
+	}
+	i = NewIndex()
+	errs = i.LoadFS(os.DirFS("testdata/035-template-file-outside-root/model"))
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "template-1.yaml: template template-1: file ../outside.tmpl is outside of the filesystem" {
+		t.Errorf("Expected file outside error, got %s", errs[0].Error())
+	}
+}
+
+// Test_Index_Load_TemplateFileSymlinkOutsideRoot tests the Load function of
+// the Index. It expects an error for a template file that is a symlink to a
+// file outside of the loaded directory.
+func Test_Index_Load_TemplateFileSymlinkOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model")
+	if err := os.Mkdir(model, 0755); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "outside.tmpl"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside.tmpl"), filepath.Join(model, "link.tmpl")); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	content := "apiVersion: v1\ntemplate:\n  name: template-1\n  file: link.tmpl\n"
+	if err := ioutil.WriteFile(filepath.Join(model, "template-1.yaml"), []byte(content), 0644); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	i := NewIndex()
+	errs := i.Load(model)
+	expected := filepath.Join(model, "template-1.yaml") + ": template template-1: file link.tmpl is outside of " + model
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, errs)
+	}
+}
+
+// Test_Index_AddGenerator tests the AddGenerator function of the Index. It
+// adds one Generator and then checks that it was added.
+func Test_Index_AddGenerator(t *testing.T) {
+	i := NewIndex()
+	err := i.AddGenerator(&v1.Generator{
+		APIVersion: "v1",
+		Generator: v1.GeneratorSpec{
+			Name:     "generator-1",
+			Selector: v1.SelectorSpec{Kind: "test"},
+		},
+	})
+	if err != nil {
+		t.Errorf("Expected nil, got %s", err.Error())
+	}
+	if len(i.generator) != 1 {
+		t.Errorf("Expected 1 generator, got %d", len(i.generator))
+	}
+	if i.generator["generator-1"].Generator.Selector.Kind != "test" {
+		t.Errorf("Expected test, got %s", i.generator["generator-1"].Generator.Selector.Kind)
+	}
+}
+
+// Test_Index_RemoveGenerator_Missing tests the RemoveGenerator function of
+// the Index. It removes one Generator that does not exist and then checks
+// that it was not removed.
+func Test_Index_RemoveGenerator_Missing(t *testing.T) {
+	i := NewIndex()
+	err := i.RemoveGenerator(&v1.Generator{
+		APIVersion: "v1",
+		Generator:  v1.GeneratorSpec{Name: "generator-1"},
+	})
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if err.Error() != "generator generator-1 does not exist" {
+		t.Errorf("Expected error 'generator generator-1 does not exist', got %s", err.Error())
+	}
+}
+
+// Test_Index_SelectResources tests the SelectResources function of the Index.
+// It expects the resources of the selected kind with all the selected labels,
+// sorted by name.
+func Test_Index_SelectResources(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/037-generator")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	selected := i.SelectResources(&v1.SelectorSpec{Kind: "service", Labels: map[string]string{"env": "prod"}})
+	if len(selected) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(selected))
+	}
+	if selected[0].Resource.Name != "service-1" || selected[1].Resource.Name != "service-3" {
+		t.Errorf("Expected service-1 and service-3, got %s and %s", selected[0].Resource.Name, selected[1].Resource.Name)
+	}
+	if len(i.SelectResources(&v1.SelectorSpec{Kind: "service"})) != 3 {
+		t.Errorf("Expected 3 resources")
+	}
+}
+
+// Test_Index_AggregatesSelecting tests the AggregatesSelecting function of
+// the Index. It expects the aggregates whose selectors match a resource.
+func Test_Index_AggregatesSelecting(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/041-aggregate")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	selecting := i.AggregatesSelecting(i.GetResource("service", "service-1"))
+	if len(selecting) != 1 || selecting[0].Aggregate.Name != "codeowners" {
+		t.Errorf("Expected codeowners, got %v", selecting)
+	}
+	if len(i.AggregatesSelecting(i.GetResource("database", "database-1"))) != 0 {
+		t.Errorf("Expected no aggregates to select database-1")
+	}
+	if len(i.AggregateMembers(i.aggregate["codeowners"])) != 2 {
+		t.Errorf("Expected 2 members")
+	}
+}
+
+// Test_Index_Load_Formats tests the Load function of the Index. It expects
+// documents to be loaded from JSON and TOML files in the same way as from
+// YAML files.
+func Test_Index_Load_Formats(t *testing.T) {
+	i := mustLoad(t, "testdata/045-formats")
+	res := i.GetResource("test", "resource-1")
+	if res == nil {
+		t.Fatalf("Expected resource-1, got nil")
+	}
+	if res.Resource.Labels["env"] != "prod" {
+		t.Errorf("Expected prod, got %s", res.Resource.Labels["env"])
+	}
+	if _, ok := i.template["template-1"]; !ok {
+		t.Errorf("Expected template-1, got nil")
+	}
+	if _, ok := i.repository["repo-1"]; !ok {
+		t.Errorf("Expected repo-1, got nil")
+	}
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "resource-1 8080 0.5 b" {
+		t.Errorf("Expected resource-1 8080 0.5 b, got %v", artifacts)
+	}
+}
+
+// Test_Index_Load_CorruptedFormats tests the Load function of the Index. It
+// expects errors for corrupted JSON and TOML documents, with the positions
+// of the errors in the documents.
+func Test_Index_Load_CorruptedFormats(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/046-load-corrupted-formats")
+	expected := []string{
+		"testdata/046-load-corrupted-formats/resource-1.json: json: line 6, column 3: invalid character '}' looking for beginning of object key string",
+		"testdata/046-load-corrupted-formats/template-1.toml: toml: line 6 (last key \"template.content\"): expected value but found '\\n' instead",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("Expected %s, got %s", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Index_Load_MultipleDocuments tests the Load function of the Index. It
+// expects every document of a multi-document YAML file to be loaded, and the
+// source of each document to record its file, index and lines.
+func Test_Index_Load_MultipleDocuments(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/048-multiple-documents")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := map[string]string{
+		"resource:test/resource-1": "testdata/048-multiple-documents/resources.yaml:3-6",
+		"resource:test/resource-2": "testdata/048-multiple-documents/resources.yaml:10-13",
+	}
+	for id, e := range expected {
+		sources := i.Sources(id)
+		if len(sources) != 1 {
+			t.Fatalf("Expected 1 source for %s, got %v", id, sources)
+		}
+		if sources[0].String() != e {
+			t.Errorf("Expected %s, got %s", e, sources[0].String())
+		}
+	}
+	if d := i.Sources("resource:test/resource-2")[0].Document; d != 1 {
+		t.Errorf("Expected document 1, got %d", d)
+	}
+	sources := i.Sources("template:template-1")
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %v", sources)
+	}
+	if sources[1].String() != "testdata/048-multiple-documents/template-1.tmpl:1-2" {
+		t.Errorf("Expected testdata/048-multiple-documents/template-1.tmpl:1-2, got %s", sources[1].String())
+	}
+	i.RemoveResource(i.GetResource("test", "resource-1"))
+	if sources := i.Sources("resource:test/resource-1"); len(sources) != 0 {
+		t.Errorf("Expected 0 sources, got %v", sources)
+	}
+}
+
+// Test_Index_Load_DocumentSeparator tests the Load function of the Index. It
+// expects the content following a document separator on its line to be the
+// start of the next document.
+func Test_Index_Load_DocumentSeparator(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/069-document-separator")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := map[string]string{
+		"resource:test/resource-1": "testdata/069-document-separator/resources.yaml:1",
+		"resource:test/resource-2": "testdata/069-document-separator/resources.yaml:3-6",
+	}
+	for id, e := range expected {
+		sources := i.Sources(id)
+		if len(sources) != 1 {
+			t.Fatalf("Expected 1 source for %s, got %v", id, sources)
+		}
+		if sources[0].String() != e {
+			t.Errorf("Expected %s, got %s", e, sources[0].String())
+		}
+	}
+}
+
+// Test_Index_Load_MultipleDocumentsErrors tests the Load function of the
+// Index. It expects a duplicate document in the same file to cite both
+// documents, and YAML errors to report the lines of the file.
+func Test_Index_Load_MultipleDocumentsErrors(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/049-multiple-documents-errors")
+	expected := []string{
+		"testdata/049-multiple-documents-errors/resources.yaml:6-9: resource resource-1 of kind test already exists, first defined at testdata/049-multiple-documents-errors/resources.yaml:1-4",
+		"testdata/049-multiple-documents-errors/resources.yaml: yaml: line 14: mapping values are not allowed in this context",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("Expected %s, got %s", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Index_Load_V2 tests the Load function of the Index. It expects v2
+// documents to be converted and loaded alongside v1 documents.
+func Test_Index_Load_V2(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/050-v2")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	res := i.GetResource("test", "resource-1")
+	if res == nil {
+		t.Fatalf("Expected resource-1, got nil")
+	}
+	if res.APIVersion != "v1" {
+		t.Errorf("Expected v1, got %s", res.APIVersion)
+	}
+	if res.Resource.Labels["env"] != "prod" {
+		t.Errorf("Expected prod, got %s", res.Resource.Labels["env"])
+	}
+	if len(res.Resource.DependsOn) != 1 || res.Resource.DependsOn[0].Name != "resource-2" {
+		t.Errorf("Expected resource-2, got %v", res.Resource.DependsOn)
+	}
+	output := res.Resource.Outputs[0]
+	if output.Repository != "repo-1" || output.File != "resource-1.txt" || output.Template != "template-1" {
+		t.Errorf("Expected repo-1, resource-1.txt and template-1, got %v", output)
+	}
+	if r := i.repository["repo-1"]; r == nil || r.Repository.Repository != "test-repo-1" {
+		t.Errorf("Expected test-repo-1, got %v", r)
+	}
+	artifacts, renderErrs := NewRenderer(i).Render(context.Background())
+	if len(renderErrs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", renderErrs)
+	}
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "resource-1 8080" {
+		t.Errorf("Expected resource-1 8080, got %v", artifacts)
+	}
+}
+
+// Test_Index_Load_InvalidV2 tests the Load function of the Index. It expects
+// errors for v2 documents with unknown fields, output types and kinds.
+func Test_Index_Load_InvalidV2(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/051-load-invalid-v2")
+	expected := []string{
+		"testdata/051-load-invalid-v2/resource-1.yaml: yaml: unmarshal errors:\n  line 7: field port not found in type v2.ResourceSpec",
+		"testdata/051-load-invalid-v2/resource-2.yaml: output output-1: unknown type http",
+		"testdata/051-load-invalid-v2/resource-3.yaml: unknown kind Service",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("Expected %s, got %s", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Index_Load_Namespaces tests the Load function of the Index. It expects
+// resources of the same kind and name in different namespaces to be loaded,
+// keyed by their qualified names.
+func Test_Index_Load_Namespaces(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/052-namespaces")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	for _, name := range []string{"orders", "team-a/orders"} {
+		if i.GetResource("database", name) == nil {
+			t.Errorf("Expected database %s, got nil", name)
+		}
+	}
+	if r := i.GetResource("service", "team-b/orders"); r == nil || r.Resource.Namespace != "team-b" {
+		t.Errorf("Expected service team-b/orders, got %v", r)
+	}
+	if _, ok := i.template["team-a/config"]; !ok {
+		t.Errorf("Expected template team-a/config, got nil")
+	}
+	namespaces := i.Namespaces()
+	if len(namespaces) != 2 || namespaces[0] != "team-a" || namespaces[1] != "team-b" {
+		t.Errorf("Expected [team-a team-b], got %v", namespaces)
+	}
+	if sources := i.Sources("resource:database/team-a/orders"); len(sources) != 1 || sources[0].String() != "testdata/052-namespaces/team-a.yaml:14-20" {
+		t.Errorf("Expected testdata/052-namespaces/team-a.yaml:14-20, got %v", sources)
+	}
+}
+
+// Test_Index_ResolveResource tests the ResolveResource function of the Index.
+// It expects references with no namespace to be looked up in the namespace of
+// the referrer and then in the default namespace, and references with a
+// namespace to be looked up in it only.
+func Test_Index_ResolveResource(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/052-namespaces")
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	tests := []struct {
+		namespace string
+		ref       v1.ReferenceSpec
+		expected  string
+	}{
+		{"team-a", v1.ReferenceSpec{Kind: "database", Name: "orders"}, "team-a"},
+		{"team-b", v1.ReferenceSpec{Kind: "database", Name: "orders"}, ""},
+		{"", v1.ReferenceSpec{Kind: "database", Name: "orders"}, ""},
+		{"", v1.ReferenceSpec{Kind: "database", Name: "orders", Namespace: "team-a"}, "team-a"},
+	}
+	for _, test := range tests {
+		r := i.ResolveResource(test.namespace, test.ref)
+		if r == nil {
+			t.Errorf("Expected %v to resolve from %s, got nil", test.ref, test.namespace)
+		} else if r.Resource.Namespace != test.expected {
+			t.Errorf("Expected namespace %s, got %s", test.expected, r.Resource.Namespace)
+		}
+	}
+	if r := i.ResolveResource("", v1.ReferenceSpec{Kind: "database", Name: "orders", Namespace: "team-b"}); r != nil {
+		t.Errorf("Expected nil, got %v", r)
 	}
 }
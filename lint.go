@@ -0,0 +1,330 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Lint reports the parts of the Index that are likely dead or mistaken, as
+// warnings: templates and repositories that no output uses, resources with
+// no outputs that nothing refers to, and templates that read fields no
+// resource has in its data. Resources are not reported if a template used by
+// an output reads the context in a way that can not be tied to specific
+// resources, as any resource may then be read.
+func (i *Index) Lint() Diagnostics {
+	d := Diagnostics{}
+	warn := func(format string, args ...interface{}) {
+		d = append(d, &Diagnostic{Severity: v1.WarningSeverity, Err: fmt.Errorf(format, args...)})
+	}
+
+	templates, repositories := i.usedDocuments()
+	for _, name := range sortedTemplateNames(i.template) {
+		if !templates[name] {
+			warn("template %s is not used by any output", name)
+		}
+	}
+	for _, name := range sortedRepositoryNames(i.repository) {
+		if !repositories[name] {
+			warn("repository %s is not used by any output", name)
+		}
+	}
+
+	readsAll := false
+	for name := range templates {
+		if _, all := contextRefs(i.lookupTemplate(name)); all {
+			readsAll = true
+		}
+	}
+	if !readsAll {
+		// an edge from a resource is an output it owns or is selected by, or
+		// a resource or output that refers to it
+		g, _ := i.Graph()
+		referenced := map[string]bool{}
+		for _, e := range g.Edges {
+			referenced[e.From] = true
+		}
+		for _, kind := range sortedKeys(i.resourceByKind) {
+			for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+				if !referenced[resourceID(kind, name)] {
+					warn("resource %s of kind %s has no outputs and nothing refers to it", name, kind)
+				}
+			}
+		}
+	}
+
+	fields := dataFields{paths: map[string]bool{}, refs: map[string]bool{}}
+	for _, resources := range i.resourceByKind {
+		for _, r := range resources {
+			fields.add(r.Resource.Data, "")
+		}
+	}
+	for _, name := range sortedTemplateNames(i.template) {
+		reported := map[string]bool{}
+		walkTemplateContext(&i.template[name].Template, func(path []string) {
+			if missing := fields.missing(dataPath(path)); missing != "" && !reported[missing] {
+				reported[missing] = true
+				warn("template %s: field data.%s is not in the data of any resource", name, missing)
+			}
+		})
+	}
+	return d
+}
+
+// CheckTemplates reports the fields that the template of an output reads from
+// the context but that are not set for a resource it is rendered for, as
+// warnings, such as `.Self.data.prot` if the data of the resource has no
+// `prot` field. Such fields render as "<no value>", unless the Renderer has
+// MissingKeyError set. Each output is checked against the context it is
+// rendered with, including the data of the resources it refers to, and so
+// are the layouts and partials of its template that are executed with the
+// context rather than with a value read from it. Outputs whose template does
+// not compile or whose context fails are not checked, as Render reports
+// them, and only templates of the text and html engines are checked.
+func (i *Index) CheckTemplates() Diagnostics {
+	d := Diagnostics{}
+	base := i.defaultContext()
+	jobs := i.renderJobs()
+	for n := range jobs {
+		job := &jobs[n]
+		name := i.templateName(job.namespace(), job.output.Template)
+		if _, err := i.parsedTemplate(name); err != nil {
+			continue
+		}
+		data, err := job.context(i, base)
+		if err != nil {
+			continue
+		}
+		deps := i.templates.deps(name)
+		specs := map[string]*parsedSpec{}
+		notRoot := map[string]bool{}
+		for _, t := range deps {
+			spec := i.lookupTemplate(t)
+			if spec.Engine != "" && spec.Engine != "text" && spec.Engine != "html" {
+				continue
+			}
+			p, err := parseSpec(spec)
+			if err != nil {
+				continue
+			}
+			specs[t] = p
+			for _, tree := range p.trees {
+				walkInvocations(tree.Root, true, func(name string, root bool) {
+					if !root {
+						notRoot[name] = true
+						notRoot[templateRef(spec.Namespace, name, i.lookupTemplate)] = true
+					}
+				})
+			}
+		}
+		for _, t := range deps {
+			if specs[t] == nil {
+				continue
+			}
+			trees := []string{}
+			for tree := range specs[t].trees {
+				if !notRoot[tree] {
+					trees = append(trees, tree)
+				}
+			}
+			sort.Strings(trees)
+			reported := map[string]bool{}
+			for _, tree := range trees {
+				walkContext(specs[t].trees[tree].Root, true, func(path []string) {
+					field := strings.Join(path, ".")
+					if reported[field] {
+						return
+					}
+					if _, ok := resolvePath(reflect.ValueOf(data), path); !ok {
+						reported[field] = true
+						d = append(d, &Diagnostic{
+							Severity: v1.WarningSeverity,
+							Err:      job.error(fmt.Errorf("template %s: .%s has no value", t, field)),
+						})
+					}
+				})
+			}
+		}
+	}
+	return d
+}
+
+// walkInvocations calls fn with the name of every template that node
+// executes with `template`, `block` or `include`, and whether it is executed
+// with the root of the context, that is with `$` or with the dot when root is
+// true.
+func walkInvocations(node parse.Node, root bool, fn func(name string, root bool)) {
+	// withRoot returns true if the argument of an invocation is the root
+	withRoot := func(arg parse.Node) bool {
+		if v, ok := arg.(*parse.VariableNode); ok {
+			return len(v.Ident) == 1 && v.Ident[0] == "$"
+		}
+		_, dot := arg.(*parse.DotNode)
+		return root && dot
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkInvocations(c, root, fn)
+		}
+	case *parse.ActionNode:
+		walkInvocations(n.Pipe, root, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkInvocations(c, root, fn)
+		}
+	case *parse.CommandNode:
+		if name := includedName(n); name != "" {
+			fn(name, len(n.Args) == 3 && withRoot(n.Args[2]))
+		}
+		for _, arg := range n.Args {
+			walkInvocations(arg, root, fn)
+		}
+	case *parse.IfNode:
+		walkInvocations(n.Pipe, root, fn)
+		walkInvocations(n.List, root, fn)
+		walkInvocations(n.ElseList, root, fn)
+	case *parse.RangeNode:
+		walkInvocations(n.Pipe, root, fn)
+		walkInvocations(n.List, false, fn)
+		walkInvocations(n.ElseList, root, fn)
+	case *parse.WithNode:
+		walkInvocations(n.Pipe, root, fn)
+		walkInvocations(n.List, false, fn)
+		walkInvocations(n.ElseList, root, fn)
+	case *parse.TemplateNode:
+		fn(n.Name, n.Pipe != nil && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 && withRoot(n.Pipe.Cmds[0].Args[0]))
+		walkInvocations(n.Pipe, root, fn)
+	}
+}
+
+// usedDocuments returns the qualified names of the templates and
+// repositories that outputs use. The templates of an output are the template
+// it names and the layouts and partials it is compiled from. The repository
+// of an output is rendered for each resource the output is rendered for,
+// and is used even if there is none if it is not a template, as is the
+// template of the output.
+func (i *Index) usedDocuments() (map[string]bool, map[string]bool) {
+	templates := map[string]bool{}
+	repositories := map[string]bool{}
+	useTemplate := func(namespace string, name string) {
+		name = i.templateName(namespace, name)
+		if _, err := i.parsedTemplate(name); err != nil {
+			return
+		}
+		for _, d := range i.templates.deps(name) {
+			templates[d] = true
+		}
+	}
+	useRepository := func(namespace string, name string) {
+		if name != "" {
+			repositories[i.repositoryName(namespace, name)] = true
+		}
+	}
+
+	for _, name := range sortedGeneratorNames(i.generator) {
+		for _, o := range i.generator[name].Generator.Outputs {
+			useTemplate("", o.Template)
+			if !strings.Contains(o.Repository, "{{") {
+				useRepository("", o.Repository)
+			}
+		}
+	}
+	for _, name := range sortedAggregateNames(i.aggregate) {
+		for _, o := range i.aggregate[name].Aggregate.Outputs {
+			useTemplate("", o.Template)
+			if !strings.Contains(o.Repository, "{{") {
+				useRepository("", o.Repository)
+			}
+		}
+	}
+	base := i.defaultContext()
+	for _, job := range i.renderJobs() {
+		useTemplate(job.namespace(), job.output.Template)
+		data, err := job.context(i, base)
+		if err != nil {
+			continue
+		}
+		if repository, err := renderString("repository", job.output.Repository, data); err == nil {
+			useRepository(job.namespace(), repository)
+		}
+	}
+	return templates, repositories
+}
+
+// dataPath returns the path into the data of a resource of a reference to the
+// context, such as `port` for `.Self.data.port` or
+// `.Resources.database.orders.Data.port`, or nil if it is not one.
+func dataPath(path []string) []string {
+	switch {
+	case len(path) > 2 && (path[0] == "Self" || path[0] == "self") && path[1] == "data":
+		return path[2:]
+	case len(path) > 4 && (path[0] == "Resources" || path[0] == "resources") && (path[3] == "Data" || path[3] == "data"):
+		return path[4:]
+	}
+	return nil
+}
+
+// dataFields are the fields of the data of resources.
+type dataFields struct {
+	// paths are the dot separated paths of the fields.
+	paths map[string]bool
+	// refs are the paths of the references, under which any field may be
+	// once they are expanded.
+	refs map[string]bool
+}
+
+// add adds the fields of data, at the path prefix.
+func (f *dataFields) add(data interface{}, prefix string) {
+	if ref, _ := dataReference(data); ref != nil {
+		f.refs[prefix] = true
+		return
+	}
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			f.paths[join(fmt.Sprint(k))] = true
+			f.add(e, join(fmt.Sprint(k)))
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			f.paths[join(k)] = true
+			f.add(e, join(k))
+		}
+	case []interface{}:
+		for _, e := range v {
+			f.add(e, prefix)
+		}
+	}
+}
+
+// missing returns the first prefix of path that is not a field, or an empty
+// string if every field of path is known.
+func (f *dataFields) missing(path []string) string {
+	for n := range path {
+		p := strings.Join(path[:n+1], ".")
+		if f.refs[p] {
+			return ""
+		}
+		if !f.paths[p] {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_Lint tests the Lint function of the Index. It expects warnings
+// for unused templates and repositories, for resources with no outputs that
+// nothing refers to, and for fields of the data read by templates that no
+// resource has, but not for partials, referenced resources or fields under
+// a reference.
+func Test_Index_Lint(t *testing.T) {
+	i := mustLoad(t, "testdata/060-lint")
+	diags := i.Lint()
+	expected := []string{
+		"template unused is not used by any output",
+		"repository repo-2 is not used by any output",
+		"resource orders of kind queue has no outputs and nothing refers to it",
+		"template config: field data.tls.cert is not in the data of any resource",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), diags)
+	}
+	for n, e := range expected {
+		if diags[n].Severity != v1.WarningSeverity || diags[n].Error() != e {
+			t.Errorf("Expected warning %s, got %s %s", e, diags[n].Severity, diags[n])
+		}
+	}
+}
+
+// Test_Index_Lint_ReadsAll tests the Lint function of the Index. It expects
+// no warning for resources when a template reads every resource of the
+// context.
+func Test_Index_Lint_ReadsAll(t *testing.T) {
+	i := mustLoad(t, "testdata/060-lint")
+	replaceTemplate(t, i, "header", `{{ range $k, $v := .Resources }}{{ $k }}{{ end }}`)
+	for _, d := range i.Lint() {
+		if d.Error() == "resource orders of kind queue has no outputs and nothing refers to it" {
+			t.Errorf("Expected no warning for resources, got %s", d)
+		}
+	}
+}
+
+// Test_Index_CheckTemplates tests the CheckTemplates function of the Index.
+// It expects a warning for each field read by the template of an output, or
+// by its partials, that the context of a resource it is rendered for does not
+// have, but none for the resources that have them.
+func Test_Index_CheckTemplates(t *testing.T) {
+	i := mustLoad(t, "testdata/061-template-check")
+	diags := i.CheckTemplates()
+	expected := []string{
+		"resource billing of kind service: output config: template config: .Self.data.port has no value",
+		"resource billing of kind service: output config: template header: .Self.labels.team has no value",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), diags)
+	}
+	for n, e := range expected {
+		if diags[n].Severity != v1.WarningSeverity || diags[n].Error() != e {
+			t.Errorf("Expected warning %s, got %s %s", e, diags[n].Severity, diags[n])
+		}
+	}
+}
+
+// Test_Index_CheckTemplates_Partials tests the CheckTemplates function of the
+// Index. It expects no warning for the fields read by partials executed with
+// a value read from the context rather than with the context.
+func Test_Index_CheckTemplates_Partials(t *testing.T) {
+	i := mustLoad(t, "testdata/061-template-check")
+	replaceTemplate(t, i, "header", `{{ define "db" }}{{ .host }}{{ end }}{{ template "db" .Resources.database.orders.Data }}`)
+	diags := i.CheckTemplates()
+	expected := "resource billing of kind service: output config: template config: .Self.data.port has no value"
+	if len(diags) != 1 || diags[0].Error() != expected {
+		t.Errorf("Expected warning %s, got %v", expected, diags)
+	}
+}
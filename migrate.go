@@ -0,0 +1,304 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	v2 "github.com/tpology/core/api/v2"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Migrate converts the v1 documents in the content of the file at path to
+// v2, and returns the new content and whether any document was converted.
+// In YAML files, only the lines of v1 documents are rewritten, and the
+// comments of their fields are kept. JSON and TOML files are encoded again,
+// so the comments of TOML files are lost. The content is returned unchanged
+// if the file holds no document or no v1 document, and an error is returned
+// if a v1 document is invalid.
+func Migrate(path string, content []byte) ([]byte, bool, error) {
+	if !isDocument(path) {
+		return content, false, nil
+	}
+	docs, err := readDocuments(path, content)
+	if err != nil {
+		return nil, false, err
+	}
+	// objects are the v1 documents of the file, converted to objects
+	objects := make([]*object, len(docs))
+	migrated := false
+	for n, d := range docs {
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(d.content, &doc); err != nil {
+			return nil, false, err
+		}
+		if doc["apiVersion"] != "v1" {
+			continue
+		}
+		o, errs := converters["v1"].decode(doc, d.content)
+		if len(errs) > 0 {
+			return nil, false, fmt.Errorf("%s: %s", d.source, errs[0])
+		}
+		objects[n] = o
+		migrated = true
+	}
+	if !migrated {
+		return content, false, nil
+	}
+
+	var out []byte
+	if documentFormats[filepath.Ext(path)] == nil {
+		out, err = migrateYAML(content, docs, objects)
+	} else {
+		out, err = migrateEncoded(path, objects[0])
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := checkMigrated(path, out, objects); err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// migrateYAML replaces the lines of each document of YAML content that has
+// an object with the v2 document of the object.
+func migrateYAML(content []byte, docs []document, objects []*object) ([]byte, error) {
+	lines := strings.SplitAfter(string(content), "\n")
+	out := strings.Builder{}
+	next := 0
+	for n, d := range docs {
+		if objects[n] == nil {
+			continue
+		}
+		start, end := d.source.StartLine-1, d.source.EndLine
+		node := yaml3.Node{}
+		if err := yaml3.Unmarshal([]byte(strings.Join(lines[start:end], "")), &node); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.source, err)
+		}
+		if err := migrateNode(&node); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.source, err)
+		}
+		buf := bytes.Buffer{}
+		e := yaml3.NewEncoder(&buf)
+		e.SetIndent(2)
+		if err := e.Encode(&node); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.source, err)
+		}
+		if err := e.Close(); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.source, err)
+		}
+		out.WriteString(strings.Join(lines[next:start], ""))
+		out.Write(buf.Bytes())
+		next = end
+	}
+	out.WriteString(strings.Join(lines[next:], ""))
+	return []byte(out.String()), nil
+}
+
+// migrateEncoded returns the v2 document of the object, encoded in the
+// format of the file at path.
+func migrateEncoded(path string, o *object) ([]byte, error) {
+	doc, err := converters[v2.APIVersion].encode(o)
+	if err != nil {
+		return nil, err
+	}
+	// marshal the document as YAML first, so that its fields are named as
+	// in YAML
+	b, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) == ".toml" {
+		buf := bytes.Buffer{}
+		if err := toml.NewEncoder(&buf).Encode(jsonValue(v)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	b, err = json.MarshalIndent(jsonValue(v), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// checkMigrated returns an error if the documents in the migrated content of
+// the file at path are not the same as the objects they were migrated from.
+func checkMigrated(path string, content []byte, objects []*object) error {
+	docs, err := readDocuments(path, content)
+	if err != nil {
+		return err
+	}
+	if len(docs) != len(objects) {
+		return fmt.Errorf("expected %d documents after migrating, got %d", len(objects), len(docs))
+	}
+	for n, d := range docs {
+		if objects[n] == nil {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(d.content, &doc); err != nil {
+			return fmt.Errorf("%s: %s", d.source, err)
+		}
+		o, errs := decodeDocument(doc, d.content)
+		if len(errs) > 0 {
+			return fmt.Errorf("%s: %s", d.source, errs[0])
+		}
+		before, err := yaml.Marshal(objects[n].document())
+		if err != nil {
+			return err
+		}
+		after, err := yaml.Marshal(o.document())
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(before, after) {
+			return fmt.Errorf("%s: document changed when migrated", d.source)
+		}
+	}
+	return nil
+}
+
+// yamlMigration describes how a v1 document is rewritten as v2.
+type yamlMigration struct {
+	// kind is the kind of the v2 document.
+	kind string
+	// metadata are the fields of the v1 spec that are moved to the metadata.
+	metadata []string
+	// renames are the fields of the v1 spec that are renamed in the v2 spec.
+	renames map[string]string
+}
+
+// yamlMigrations are the migrations of v1 documents, keyed by the field of
+// their spec.
+var yamlMigrations = map[string]yamlMigration{
+	"resource":   {kind: v2.ResourceKind, metadata: []string{"name", "kind", "namespace", "labels", "annotations"}},
+	"template":   {kind: v2.TemplateKind, metadata: []string{"name", "namespace"}},
+	"repository": {kind: v2.RepositoryKind, metadata: []string{"name", "namespace", "labels", "annotations"}, renames: map[string]string{"repository": "url"}},
+	"generator":  {kind: v2.GeneratorKind, metadata: []string{"name", "labels", "annotations"}},
+	"aggregate":  {kind: v2.AggregateKind, metadata: []string{"name", "labels", "annotations"}},
+	"policy":     {kind: v2.PolicyKind, metadata: []string{"name", "labels", "annotations"}},
+}
+
+// migrateNode rewrites a v1 YAML document as v2. Fields are moved with
+// their key and value nodes, so that the comments attached to them are
+// kept.
+func migrateNode(doc *yaml3.Node) error {
+	if doc.Kind != yaml3.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml3.MappingNode {
+		return fmt.Errorf("document is not a mapping")
+	}
+	root := doc.Content[0]
+	pairs := [][2]*yaml3.Node{}
+	for n := 0; n+1 < len(root.Content); n += 2 {
+		pairs = append(pairs, [2]*yaml3.Node{root.Content[n], root.Content[n+1]})
+	}
+	content := []*yaml3.Node{}
+	for _, p := range pairs {
+		key, value := p[0], p[1]
+		m, ok := yamlMigrations[key.Value]
+		if !ok {
+			if key.Value == "apiVersion" {
+				value.Value = v2.APIVersion
+			}
+			content = append(content, key, value)
+			continue
+		}
+		if value.Kind != yaml3.MappingNode {
+			return fmt.Errorf("%s is not a mapping", key.Value)
+		}
+		// the comments of the spec field are kept on the kind
+		kind := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: "kind", HeadComment: key.HeadComment}
+		kindValue := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: m.kind, LineComment: key.LineComment}
+		metadata, spec := mappingNode(), mappingNode()
+		for n := 0; n+1 < len(value.Content); n += 2 {
+			field, fieldValue := value.Content[n], value.Content[n+1]
+			if containsString(m.metadata, field.Value) {
+				metadata.Content = append(metadata.Content, field, fieldValue)
+				continue
+			}
+			if name, ok := m.renames[field.Value]; ok {
+				field.Value = name
+			}
+			if field.Value == "outputs" && fieldValue.Kind == yaml3.SequenceNode {
+				for _, o := range fieldValue.Content {
+					if o.Kind == yaml3.MappingNode {
+						migrateOutputNode(o)
+					}
+				}
+			}
+			spec.Content = append(spec.Content, field, fieldValue)
+		}
+		content = append(content,
+			kind, kindValue,
+			scalarNode("metadata"), flowIfEmpty(metadata),
+			scalarNode("spec"), flowIfEmpty(spec),
+		)
+	}
+	root.Content = content
+	return nil
+}
+
+// migrateOutputNode rewrites a v1 output as a v2 file output, moving its
+// repository and file to its file target.
+func migrateOutputNode(output *yaml3.Node) {
+	content := []*yaml3.Node{}
+	typeAdded := false
+	file := mappingNode()
+	for n := 0; n+1 < len(output.Content); n += 2 {
+		field, value := output.Content[n], output.Content[n+1]
+		switch field.Value {
+		case "repository":
+			file.Content = append(file.Content, field, value)
+		case "file":
+			field.Value = "path"
+			file.Content = append(file.Content, field, value)
+		default:
+			content = append(content, field, value)
+			if field.Value == "name" {
+				content = append(content, scalarNode("type"), scalarNode(v2.FileOutputType))
+				typeAdded = true
+			}
+		}
+	}
+	if !typeAdded {
+		content = append([]*yaml3.Node{scalarNode("type"), scalarNode(v2.FileOutputType)}, content...)
+	}
+	output.Content = append(content, scalarNode("file"), flowIfEmpty(file))
+}
+
+// scalarNode returns a string node.
+func scalarNode(value string) *yaml3.Node {
+	return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// mappingNode returns an empty mapping node.
+func mappingNode() *yaml3.Node {
+	return &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+}
+
+// flowIfEmpty writes the mapping node in flow style if it is empty, as {}.
+func flowIfEmpty(n *yaml3.Node) *yaml3.Node {
+	if len(n.Content) == 0 {
+		n.Style = yaml3.FlowStyle
+	}
+	return n
+}
+
+// containsString returns true if s is in list.
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"testing"
+)
+
+// Test_Migrate tests the Migrate function. It expects the v1 documents of a
+// YAML file to be rewritten as v2 with their comments, and the other
+// documents and comments of the file to be kept as they are.
+func Test_Migrate(t *testing.T) {
+	content := `# services of team-1
+---
+# the orders service
+apiVersion: v1
+resource:
+  name: orders # the name
+  kind: service
+  # deployed in production
+  labels:
+    env: prod
+  outputs:
+  - name: config
+    repository: repo-1
+    # one file per service
+    file: orders.yaml
+    template: service
+---
+apiVersion: v2
+kind: Template
+metadata: {name: service}
+spec: {content: "{{ .Self.name }}"}
+---
+apiVersion: v1
+repository:
+  name: repo-1
+  repository: test-repo-1
+  branch: main
+`
+	expected := `# services of team-1
+---
+# the orders service
+apiVersion: v2
+kind: Resource
+metadata:
+  name: orders # the name
+  kind: service
+  # deployed in production
+  labels:
+    env: prod
+spec:
+  outputs:
+    - name: config
+      type: file
+      template: service
+      file:
+        repository: repo-1
+        # one file per service
+        path: orders.yaml
+---
+apiVersion: v2
+kind: Template
+metadata: {name: service}
+spec: {content: "{{ .Self.name }}"}
+---
+apiVersion: v2
+kind: Repository
+metadata:
+  name: repo-1
+spec:
+  url: test-repo-1
+  branch: main
+`
+	migrated, changed, err := Migrate("model.yaml", []byte(content))
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if !changed {
+		t.Errorf("Expected true, got false")
+	}
+	if string(migrated) != expected {
+		t.Errorf("Expected %s, got %s", expected, migrated)
+	}
+	_, changed, err = Migrate("model.yaml", migrated)
+	if err != nil || changed {
+		t.Errorf("Expected nil and false, got %v and %t", err, changed)
+	}
+}
+
+// Test_Migrate_JSON tests the Migrate function. It expects a v1 JSON
+// document to be encoded again as v2.
+func Test_Migrate_JSON(t *testing.T) {
+	content := `{"apiVersion": "v1", "template": {"name": "template-1", "content": "{{ .Self.name }}"}}`
+	expected := `{
+  "apiVersion": "v2",
+  "kind": "Template",
+  "metadata": {
+    "name": "template-1"
+  },
+  "spec": {
+    "content": "{{ .Self.name }}"
+  }
+}
+`
+	migrated, changed, err := Migrate("template-1.json", []byte(content))
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if !changed || string(migrated) != expected {
+		t.Errorf("Expected %s, got %s", expected, migrated)
+	}
+}
+
+// Test_Migrate_Invalid tests the Migrate function. It expects an error for
+// an invalid v1 document, and files that hold no document to be unchanged.
+func Test_Migrate_Invalid(t *testing.T) {
+	_, _, err := Migrate("resource-1.yaml", []byte("apiVersion: v1\nresource:\n  name: a\n  port: 80\n"))
+	if err == nil || err.Error() != "resource-1.yaml:1-4: invalid resource spec field `port`" {
+		t.Errorf("Expected resource-1.yaml:1-4: invalid resource spec field `port`, got %v", err)
+	}
+	_, changed, err := Migrate("template-1.tmpl", []byte("apiVersion: v1\n"))
+	if err != nil || changed {
+		t.Errorf("Expected nil and false, got %v and %t", err, changed)
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// policyFuncs are the functions the conditions and messages of rules are
+// executed with, in addition to those of computed fields.
+var policyFuncs = template.FuncMap{
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"contains":  strings.Contains,
+	"matches":   regexp.MatchString,
+}
+
+// policyID returns the ID of a policy, under which its sources are recorded.
+// Policies are not nodes of the graph.
+func policyID(name string) string {
+	return "policy:" + name
+}
+
+// PolicyViolation is a target that does not follow a rule of a policy.
+type PolicyViolation struct {
+	Policy string `json:"policy"`
+	Rule   string `json:"rule"`
+	// Severity is the severity of the rule, error or warning.
+	Severity string `json:"severity"`
+	// Target is the graph node ID of the resource, repository or output
+	// that does not follow the rule, such as `resource:service/orders`.
+	Target string `json:"target"`
+	// Message is the message of the rule executed for the target.
+	Message string `json:"message"`
+}
+
+// Error implements error
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("policy %s: rule %s: %s: %s", v.Policy, v.Rule, v.Target, v.Message)
+}
+
+// parsedRule is a rule of a policy, with its condition and message parsed.
+type parsedRule struct {
+	policy    *v1.Policy
+	rule      *v1.RuleSpec
+	condition *template.Template
+	message   *template.Template
+}
+
+// parseRule parses the condition and message of a rule of a policy.
+func parseRule(p *v1.Policy, rule *v1.RuleSpec) (*parsedRule, error) {
+	name := p.Policy.Name + "/" + rule.Name
+	condition, err := template.New(name).Funcs(computeFuncs).Funcs(policyFuncs).Parse(rule.Condition)
+	if err != nil {
+		return nil, err
+	}
+	message, err := template.New(name).Funcs(computeFuncs).Funcs(policyFuncs).Parse(rule.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedRule{policy: p, rule: rule, condition: condition, message: message}, nil
+}
+
+// selects returns true if the selector of the rule selects a target of
+// kind, in namespace and with labels. The kind of the selector does not
+// apply to repositories.
+func (r *parsedRule) selects(kind string, namespace string, labels map[string]string) bool {
+	s := r.rule.Selector
+	if s == nil {
+		return true
+	}
+	if (s.Kind != "" && s.Kind != kind && r.rule.Target != v1.RepositoryTarget) || (s.Namespace != "" && s.Namespace != namespace) {
+		return false
+	}
+	for k, v := range s.Labels {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// check executes the condition of the rule with data, and returns a
+// violation for target if it does not output `true`.
+func (r *parsedRule) check(target string, data *v1.PolicyContext) (*PolicyViolation, error) {
+	buf := bytes.Buffer{}
+	if err := r.condition.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(buf.String()) == "true" {
+		return nil, nil
+	}
+	message := "condition is not met"
+	if r.rule.Message != "" {
+		buf.Reset()
+		if err := r.message.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		message = buf.String()
+	}
+	severity := r.rule.Severity
+	if severity == "" {
+		severity = v1.ErrorSeverity
+	}
+	return &PolicyViolation{
+		Policy:   r.policy.Policy.Name,
+		Rule:     r.rule.Name,
+		Severity: severity,
+		Target:   target,
+		Message:  message,
+	}, nil
+}
+
+// CheckPolicies checks every rule of every policy of the Index against its
+// targets, and returns the violations in the order of the policies, sorted
+// by name, of their rules and of the targets. Errors are returned for rules
+// whose condition or message fail to execute. The outputs of resources,
+// generators and aggregates are checked with their repository and file
+// rendered. Load and Import return the violations as diagnostics with the
+// severity of their rule.
+func (i *Index) CheckPolicies() ([]*PolicyViolation, []error) {
+	violations := []*PolicyViolation{}
+	errs := []error{}
+	rules := []*parsedRule{}
+	for _, name := range sortedPolicyNames(i.policy) {
+		p := i.policy[name]
+		for n := range p.Policy.Rules {
+			r, err := parseRule(p, &p.Policy.Rules[n])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("policy %s: rule %s: %s", p.Policy.Name, p.Policy.Rules[n].Name, err))
+				continue
+			}
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return violations, errs
+	}
+
+	base := i.defaultContext()
+	jobs := i.renderJobs()
+	for _, r := range rules {
+		// check adds the violation of the rule by target, if any
+		check := func(target string, data *v1.PolicyContext) {
+			v, err := r.check(target, data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("policy %s: rule %s: %s: %s", r.policy.Policy.Name, r.rule.Name, target, err))
+			} else if v != nil {
+				violations = append(violations, v)
+			}
+		}
+		switch r.rule.Target {
+		case v1.ResourceTarget:
+			for _, kind := range sortedKeys(i.resourceByKind) {
+				for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+					res := i.resourceByKind[kind][name]
+					if !r.selects(kind, res.Resource.Namespace, res.Resource.Labels) {
+						continue
+					}
+					data := &v1.PolicyContext{DefaultContext: *base}
+					self, err := i.expandedSpecMap(res)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("policy %s: rule %s: %s: %s", r.policy.Policy.Name, r.rule.Name, resourceID(kind, name), err))
+						continue
+					}
+					data.Self = self
+					data.Namespace = res.Resource.Namespace
+					check(resourceID(kind, name), data)
+				}
+			}
+		case v1.RepositoryTarget:
+			for _, name := range sortedRepositoryNames(i.repository) {
+				repo := &i.repository[name].Repository
+				if !r.selects("", repo.Namespace, repo.Labels) {
+					continue
+				}
+				data := &v1.PolicyContext{DefaultContext: *base}
+				data.Self = repositorySpecMap(repo)
+				data.Namespace = repo.Namespace
+				check(repositoryID(name), data)
+			}
+		case v1.OutputTarget:
+			for n := range jobs {
+				job := &jobs[n]
+				selected := false
+				if job.aggregate != nil {
+					selected = r.selects("", "", job.aggregate.Aggregate.Labels)
+				} else {
+					selected = r.selects(job.resource.Resource.Kind, job.resource.Resource.Namespace, job.resource.Resource.Labels)
+				}
+				if !selected {
+					continue
+				}
+				id := outputID(job.artifact())
+				ctx, err := job.context(i, base)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("policy %s: rule %s: %s: %s", r.policy.Policy.Name, r.rule.Name, id, err))
+					continue
+				}
+				output, err := i.outputSpecMap(job, ctx)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("policy %s: rule %s: %s: %s", r.policy.Policy.Name, r.rule.Name, id, err))
+					continue
+				}
+				check(id, &v1.PolicyContext{DefaultContext: *ctx, Output: output})
+			}
+		}
+	}
+	return violations, errs
+}
+
+// policyDiagnostics returns the violations of rules, with the severity of
+// their rule, and the errors of rules that fail to execute.
+func (i *Index) policyDiagnostics() Diagnostics {
+	violations, errs := i.CheckPolicies()
+	d := errorDiagnostics(errs)
+	for _, v := range violations {
+		d = append(d, &Diagnostic{Severity: v.Severity, Err: v})
+	}
+	return d
+}
+
+// repositorySpecMap returns the repository spec as a map, keyed like its
+// YAML fields.
+func repositorySpecMap(spec *v1.RepositorySpec) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        spec.Name,
+		"namespace":   spec.Namespace,
+		"repository":  spec.Repository,
+		"branch":      spec.Branch,
+		"labels":      spec.Labels,
+		"annotations": spec.Annotations,
+	}
+}
+
+// outputSpecMap returns the output spec of the job as a map, keyed like its
+// YAML fields, with its repository and file rendered with data.
+func (i *Index) outputSpecMap(job *renderJob, data *v1.DefaultContext) (map[string]interface{}, error) {
+	repository, err := renderString("repository", job.output.Repository, data)
+	if err != nil {
+		return nil, err
+	}
+	file, err := renderString("file", job.output.File, data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"name":          job.output.Name,
+		"repository":    i.repositoryName(job.namespace(), repository),
+		"file":          file,
+		"template":      job.output.Template,
+		"context":       job.output.Context,
+		"postProcessor": job.output.PostProcessor,
+	}, nil
+}
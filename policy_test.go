@@ -0,0 +1,36 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_CheckPolicies tests the CheckPolicies function of the Index. It
+// expects a violation for each target selected by a rule whose condition is
+// not true, with the severity and message of the rule.
+func Test_Index_CheckPolicies(t *testing.T) {
+	i := mustLoad(t, "testdata/057-policies")
+	violations, errs := i.CheckPolicies()
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := []PolicyViolation{
+		{Policy: "platform", Rule: "service-team", Severity: v1.WarningSeverity, Target: "resource:service/orders", Message: "service orders has no team label"},
+	}
+	if len(violations) != len(expected) {
+		t.Fatalf("Expected %d violations, got %v", len(expected), violations)
+	}
+	for n, e := range expected {
+		if *violations[n] != e {
+			t.Errorf("Expected %v, got %v", e, *violations[n])
+		}
+	}
+
+	// the repository is no longer on the main branch
+	i.repository["repo-1"].Repository.Branch = "develop"
+	violations, _ = i.CheckPolicies()
+	if len(violations) != 2 || violations[1].Error() != "policy platform: rule prod-branch: repository:repo-1: condition is not met" {
+		t.Errorf("Expected prod-branch violation, got %v", violations)
+	}
+}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// dataReference returns the reference v is, if it is a `$ref` map in the
+// data of a resource, and an error if it is a `$ref` map that is not a valid
+// reference.
+func dataReference(v interface{}) (*v1.ReferenceSpec, error) {
+	ref := singleKey(v, v1.RefKey)
+	if ref == nil {
+		return nil, nil
+	}
+	fields, ok := stringFields(ref)
+	if !ok {
+		return nil, fmt.Errorf("invalid reference, expected kind and name")
+	}
+	for k := range fields {
+		if !containsString(v1.ValidReferenceSpecFields, k) {
+			return nil, fmt.Errorf("invalid reference field `%s`", k)
+		}
+	}
+	if fields["kind"] == "" || fields["name"] == "" {
+		return nil, fmt.Errorf("invalid reference, expected kind and name")
+	}
+	return &v1.ReferenceSpec{Kind: fields["kind"], Name: fields["name"], Namespace: fields["namespace"]}, nil
+}
+
+// singleKey returns the value of v under key if v is a map whose only key is
+// key, or nil.
+func singleKey(v interface{}, key string) interface{} {
+	switch m := v.(type) {
+	case map[interface{}]interface{}:
+		if len(m) == 1 {
+			return m[key]
+		}
+	case map[string]interface{}:
+		if len(m) == 1 {
+			return m[key]
+		}
+	}
+	return nil
+}
+
+// stringFields returns the fields of v if it is a map, with the values that
+// are not strings empty, and false if it is not a map.
+func stringFields(v interface{}) (map[string]string, bool) {
+	fields := map[string]string{}
+	switch m := v.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			fields[fmt.Sprint(k)], _ = v.(string)
+		}
+	case map[string]interface{}:
+		for k, v := range m {
+			fields[k], _ = v.(string)
+		}
+	default:
+		return nil, false
+	}
+	return fields, true
+}
+
+// walkReferences calls fn with the path and reference of every `$ref` map in
+// data, such as `data.database`, or with the error if it is not a valid
+// reference. References are walked in the order of their paths.
+func walkReferences(data interface{}, path string, fn func(path string, ref *v1.ReferenceSpec, err error)) {
+	ref, err := dataReference(data)
+	if ref != nil || err != nil {
+		fn(path, ref, err)
+		return
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		values := map[string]interface{}{}
+		for k, e := range v {
+			keys = append(keys, fmt.Sprint(k))
+			values[fmt.Sprint(k)] = e
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkReferences(values[k], path+"."+k, fn)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkReferences(v[k], path+"."+k, fn)
+		}
+	case []interface{}:
+		for n, e := range v {
+			walkReferences(e, fmt.Sprintf("%s[%d]", path, n), fn)
+		}
+	}
+}
+
+// validateReferences validates the references in the data of the resource,
+// which must be valid and refer to resources of the Index, and must not refer
+// back to the resource, directly or through the data of other resources.
+func (i *Index) validateReferences(r *v1.Resource) []error {
+	errs := []error{}
+	name := qualifiedName(r.Resource.Namespace, r.Resource.Name)
+	walkReferences(r.Resource.Data, "data", func(path string, ref *v1.ReferenceSpec, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resource %s of kind %s: %s: %s", name, r.Resource.Kind, path, err))
+		} else if i.ResolveResource(r.Resource.Namespace, *ref) == nil {
+			errs = append(errs, fmt.Errorf("resource %s of kind %s: %s: resource %s of kind %s does not exist", name, r.Resource.Kind, path, qualifiedName(ref.Namespace, ref.Name), ref.Kind))
+		}
+	})
+	if len(errs) == 0 {
+		// the resources referred to report their own invalid references
+		var cycle *referenceCycleError
+		if _, err := i.expandedSpecMap(r); errors.As(err, &cycle) {
+			errs = append(errs, fmt.Errorf("resource %s of kind %s: %s", name, r.Resource.Kind, err))
+		}
+	}
+	return errs
+}
+
+// referenceCycleError is the error of a reference that refers back to a
+// resource it was expanded from.
+type referenceCycleError struct {
+	resource *v1.Resource
+}
+
+// Error implements error
+func (e *referenceCycleError) Error() string {
+	return fmt.Sprintf("reference cycle through resource %s of kind %s", qualifiedName(e.resource.Resource.Namespace, e.resource.Resource.Name), e.resource.Resource.Kind)
+}
+
+// referencedResources returns the resources the data of the resource refers
+// to, and the references that do not resolve.
+func (i *Index) referencedResources(r *v1.Resource) ([]*v1.Resource, []v1.ReferenceSpec) {
+	resources := []*v1.Resource{}
+	missing := []v1.ReferenceSpec{}
+	walkReferences(r.Resource.Data, "data", func(path string, ref *v1.ReferenceSpec, err error) {
+		if err != nil {
+			return
+		}
+		if res := i.ResolveResource(r.Resource.Namespace, *ref); res != nil {
+			resources = append(resources, res)
+		} else {
+			missing = append(missing, *ref)
+		}
+	})
+	return resources, missing
+}
+
+// referenceClosure returns the resources the data of the resources refers
+// to, directly or through the data of other resources, in the order they are
+// first referred to, and the references that do not resolve.
+func (i *Index) referenceClosure(resources []*v1.Resource) ([]*v1.Resource, []v1.ReferenceSpec) {
+	closure := []*v1.Resource{}
+	missing := []v1.ReferenceSpec{}
+	seen := map[*v1.Resource]bool{}
+	for _, r := range resources {
+		seen[r] = true
+	}
+	for len(resources) > 0 {
+		r := resources[0]
+		resources = resources[1:]
+		refs, m := i.referencedResources(r)
+		missing = append(missing, m...)
+		for _, ref := range refs {
+			if !seen[ref] {
+				seen[ref] = true
+				closure = append(closure, ref)
+				resources = append(resources, ref)
+			}
+		}
+	}
+	return closure, missing
+}
+
+// expandedSpecMap returns the resource spec as a map, like resourceSpecMap,
+// with every reference in its data replaced by the spec map of the resource
+// it refers to, expanded in the same way, so that templates can navigate
+// `.Self.data.database.data.port`. It returns an error if a reference does
+// not resolve or refers back to a resource it was expanded from.
+func (i *Index) expandedSpecMap(r *v1.Resource) (map[string]interface{}, error) {
+	return i.expandSpecMap(r, map[*v1.Resource]bool{})
+}
+
+// expandSpecMap expands the spec map of the resource, where expanding are
+// the resources being expanded.
+func (i *Index) expandSpecMap(r *v1.Resource, expanding map[*v1.Resource]bool) (map[string]interface{}, error) {
+	m := resourceSpecMap(&r.Resource)
+	if !hasReferences(r.Resource.Data) {
+		return m, nil
+	}
+	if expanding[r] {
+		return nil, &referenceCycleError{resource: r}
+	}
+	expanding[r] = true
+	defer delete(expanding, r)
+	data, err := i.expandData(r, r.Resource.Data, "data", expanding)
+	if err != nil {
+		return nil, err
+	}
+	m["data"] = data
+	return m, nil
+}
+
+// expandData returns a copy of the data of the resource at path, with its
+// references expanded.
+func (i *Index) expandData(r *v1.Resource, data interface{}, path string, expanding map[*v1.Resource]bool) (interface{}, error) {
+	ref, err := dataReference(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if ref != nil {
+		res := i.ResolveResource(r.Resource.Namespace, *ref)
+		if res == nil {
+			return nil, fmt.Errorf("%s: resource %s of kind %s does not exist", path, qualifiedName(ref.Namespace, ref.Name), ref.Kind)
+		}
+		return i.expandSpecMap(res, expanding)
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			if m[k], err = i.expandData(r, e, fmt.Sprintf("%s.%v", path, k), expanding); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if m[k], err = i.expandData(r, e, path+"."+k, expanding); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for n, e := range v {
+			if l[n], err = i.expandData(r, e, fmt.Sprintf("%s[%d]", path, n), expanding); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	}
+	return data, nil
+}
+
+// hasReferences returns true if there is a `$ref` map in data.
+func hasReferences(data interface{}) bool {
+	if ref, err := dataReference(data); ref != nil || err != nil {
+		return true
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		for _, e := range v {
+			if hasReferences(e) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			if hasReferences(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if hasReferences(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Artifact is the rendered output of a resource.
+type Artifact struct {
+	// Kind is the kind of the resource that generated the artifact.
+	Kind string
+	// Resource is the name of the resource that generated the artifact.
+	Resource string
+	// Namespace is the namespace of the resource that generated the
+	// artifact.
+	Namespace string
+	// Generator is the name of the generator that generated the artifact, or
+	// empty if the output was declared by the resource.
+	Generator string
+	// Aggregate is the name of the aggregate that generated the artifact, in
+	// which case Kind and Resource are empty.
+	Aggregate string
+	// Output is the name of the output that generated the artifact.
+	Output string
+	// Repository is the qualified name of the repository the artifact is
+	// committed to.
+	Repository string
+	// File is the path to the artifact in the repository.
+	File string
+	// Content is the rendered content of the artifact.
+	Content []byte
+
+	// secrets are the values of the secrets the artifact was rendered with.
+	secrets []string
+}
+
+// Redact returns content with the values of the secrets the artifact was
+// rendered with replaced by [REDACTED], so that the content of the artifact,
+// or a diff of it, can be logged or displayed.
+func (a *Artifact) Redact(content []byte) []byte {
+	if len(a.secrets) == 0 {
+		return content
+	}
+	return []byte(redact(string(content), a.secrets))
+}
+
+// PostProcessor transforms the output of a template into the content of an
+// artifact.
+type PostProcessor func(content []byte) ([]byte, error)
+
+// Renderer renders the outputs of the resources in an Index.
+type Renderer struct {
+	// Workers is the number of outputs rendered concurrently. If it is not
+	// positive, runtime.NumCPU() workers are used.
+	Workers int
+	// PostProcessors is the set of post-processors available to outputs,
+	// keyed by name.
+	PostProcessors map[string]PostProcessor
+	// Cache is the cache of rendered artifacts, if any. Outputs whose inputs
+	// did not change since they were cached are not rendered again.
+	Cache *RenderCache
+	// Version identifies the template functions and post-processors of the
+	// Renderer, which cannot be hashed. It is part of the cache key of every
+	// output, so changing it when they change invalidates the Cache.
+	Version string
+	// SecretProviders is the set of providers of the secrets referred to in
+	// the data of resources, keyed by name. Outputs whose context has
+	// secrets are not cached, and the values of the secrets are redacted
+	// from their errors.
+	SecretProviders map[string]SecretProvider
+	// MissingKeyError makes outputs fail to render when their template reads
+	// a key missing from a map of the context, such as a field of the data
+	// of a resource, rather than rendering "<no value>". Templates of engines
+	// that are not StrictEngines are rendered as usual.
+	MissingKeyError bool
+
+	index *Index
+}
+
+// NewRenderer returns a new Renderer for the Index
+func NewRenderer(i *Index) *Renderer {
+	return &Renderer{
+		index:           i,
+		PostProcessors:  map[string]PostProcessor{},
+		SecretProviders: map[string]SecretProvider{},
+	}
+}
+
+// renderJob is a single output of a resource to be rendered.
+type renderJob struct {
+	resource *v1.Resource
+	output   *v1.OutputSpec
+	// generator is the generator the output belongs to, if any.
+	generator *v1.Generator
+	// aggregate is the aggregate the output belongs to, if any, in which case
+	// there is no resource.
+	aggregate *v1.Aggregate
+	// members are the resources selected by the aggregate.
+	members []*v1.Resource
+}
+
+// error prefixes err with the generator, aggregate, resource and output of
+// the job.
+func (job *renderJob) error(err error) error {
+	if job.aggregate != nil {
+		return fmt.Errorf("aggregate %s: output %s: %s", job.aggregate.Aggregate.Name, job.output.Name, err)
+	}
+	spec := &job.resource.Resource
+	name := qualifiedName(spec.Namespace, spec.Name)
+	if job.generator != nil {
+		return fmt.Errorf("generator %s: resource %s of kind %s: output %s: %s", job.generator.Generator.Name, name, spec.Kind, job.output.Name, err)
+	}
+	return fmt.Errorf("resource %s of kind %s: output %s: %s", name, spec.Kind, job.output.Name, err)
+}
+
+// namespace returns the namespace the template and repository of the job
+// are looked up in, which is that of its resource.
+func (job *renderJob) namespace() string {
+	if job.resource != nil {
+		return job.resource.Resource.Namespace
+	}
+	return ""
+}
+
+// context returns the context the job is rendered with, with the references
+// in the data of its resources expanded from the Index.
+func (job *renderJob) context(i *Index, base *v1.DefaultContext) (*v1.DefaultContext, error) {
+	data := *base
+	if job.aggregate != nil {
+		data.Self = aggregateSpecMap(&job.aggregate.Aggregate)
+		data.Selected = make([]interface{}, len(job.members))
+		for n, m := range job.members {
+			spec, err := i.expandedSpecMap(m)
+			if err != nil {
+				return nil, fmt.Errorf("resource %s of kind %s: %s", qualifiedName(m.Resource.Namespace, m.Resource.Name), m.Resource.Kind, err)
+			}
+			data.Selected[n] = spec
+		}
+	} else {
+		spec, err := i.expandedSpecMap(job.resource)
+		if err != nil {
+			return nil, err
+		}
+		data.Self = spec
+		data.Namespace = job.resource.Resource.Namespace
+	}
+	return &data, nil
+}
+
+// artifact returns an artifact identifying the output of the job, without its
+// content, repository or file.
+func (job *renderJob) artifact() *Artifact {
+	a := &Artifact{Output: job.output.Name}
+	if job.aggregate != nil {
+		a.Aggregate = job.aggregate.Aggregate.Name
+	} else {
+		a.Kind = job.resource.Resource.Kind
+		a.Resource = job.resource.Resource.Name
+		a.Namespace = job.resource.Resource.Namespace
+	}
+	if job.generator != nil {
+		a.Generator = job.generator.Generator.Name
+	}
+	return a
+}
+
+// renderResult is the result of a renderJob.
+type renderResult struct {
+	artifact *Artifact
+	err      error
+}
+
+// Render renders every output of every resource, generator and aggregate in
+// the Index. Artifacts are returned in the order of the jobs they are
+// rendered from, and errors are returned in the same order, regardless of the
+// number of workers. Rendering stops early if ctx is cancelled, in which case
+// the context error is included in the returned errors. An output rendered
+// to the same file of the same repository as one before it is an error, and
+// its artifact is not returned. If the Renderer has a Cache, it is saved once
+// every output is rendered.
+func (r *Renderer) Render(ctx context.Context) ([]*Artifact, []error) {
+	jobs := r.index.renderJobs()
+	errs := []error{}
+	base := r.index.defaultContext()
+	var keys []string
+	if r.Cache != nil {
+		r.Cache.begin()
+		var err error
+		if keys, err = r.cacheKeys(jobs); err != nil {
+			// render every output without the cache
+			errs = append(errs, fmt.Errorf("cache: %s", err))
+			keys = nil
+		}
+	}
+
+	workers := r.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]renderResult, len(jobs))
+	next := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				if ctx.Err() != nil {
+					// the job was fed before the context was cancelled
+					continue
+				}
+				key := ""
+				if keys != nil {
+					key = keys[n]
+				}
+				a, err := r.renderCached(jobs[n], base, key)
+				results[n] = renderResult{artifact: a, err: err}
+			}
+		}()
+	}
+FEED:
+	for n := range jobs {
+		// select picks either case at random when both are ready, so a
+		// cancelled context is checked first
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break FEED
+		case next <- n:
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	artifacts := []*Artifact{}
+	// files are the IDs of the outputs rendered to each file, keyed by
+	// repository and file
+	files := map[[2]string]string{}
+	for n, res := range results {
+		if res.err != nil {
+			errs = append(errs, res.err)
+		} else if res.artifact != nil {
+			a := res.artifact
+			if first, ok := files[[2]string{a.Repository, a.File}]; ok {
+				// the artifact would overwrite that of another output
+				errs = append(errs, jobs[n].error(fmt.Errorf("file %s of repository %s is also rendered by %s", a.File, a.Repository, first)))
+				continue
+			}
+			files[[2]string{a.Repository, a.File}] = outputID(a)
+			artifacts = append(artifacts, a)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	} else if r.Cache != nil {
+		if err := r.Cache.save(); err != nil {
+			errs = append(errs, fmt.Errorf("cache: %s", err))
+		}
+	}
+	return artifacts, errs
+}
+
+// renderJobs returns every output of every resource in the Index, sorted by
+// resource kind and name, followed by the outputs of every generator for each
+// resource it selects, sorted by generator name and then resource name, and
+// finally the outputs of every aggregate, sorted by aggregate name.
+func (i *Index) renderJobs() []renderJob {
+	jobs := []renderJob{}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		resources := i.resourceByKind[kind]
+		for _, name := range sortedResourceNames(resources) {
+			res := resources[name]
+			for o := range res.Resource.Outputs {
+				jobs = append(jobs, renderJob{resource: res, output: &res.Resource.Outputs[o]})
+			}
+		}
+	}
+	for _, name := range sortedGeneratorNames(i.generator) {
+		g := i.generator[name]
+		for _, res := range i.SelectResources(&g.Generator.Selector) {
+			for o := range g.Generator.Outputs {
+				jobs = append(jobs, renderJob{resource: res, output: &g.Generator.Outputs[o], generator: g})
+			}
+		}
+	}
+	for _, name := range sortedAggregateNames(i.aggregate) {
+		a := i.aggregate[name]
+		members := i.AggregateMembers(a)
+		for o := range a.Aggregate.Outputs {
+			jobs = append(jobs, renderJob{output: &a.Aggregate.Outputs[o], aggregate: a, members: members})
+		}
+	}
+	return jobs
+}
+
+// renderCached returns the artifact of the job from the Cache under key, or
+// renders and caches it if there is none. A job with no key is rendered
+// without the Cache.
+func (r *Renderer) renderCached(job renderJob, base *v1.DefaultContext, key string) (*Artifact, error) {
+	if key == "" {
+		return r.render(job, base)
+	}
+	if cached := r.Cache.get(key); cached != nil {
+		a := job.artifact()
+		a.Repository, a.File, a.Content = cached.Repository, cached.File, cached.Content
+		return a, nil
+	}
+	a, err := r.render(job, base)
+	if err == nil {
+		r.Cache.put(key, a)
+	}
+	return a, err
+}
+
+// render renders a single job.
+func (r *Renderer) render(job renderJob, base *v1.DefaultContext) (_ *Artifact, err error) {
+	compiled := r.index.parsedTemplate
+	if r.MissingKeyError {
+		compiled = r.index.strictTemplate
+	}
+	t, err := compiled(r.index.templateName(job.namespace(), job.output.Template))
+	if err != nil {
+		return nil, job.error(err)
+	}
+	if job.output.Context != "" {
+		return nil, job.error(fmt.Errorf("unknown context %s", job.output.Context))
+	}
+	data, err := job.context(r.index, base)
+	if err != nil {
+		return nil, job.error(err)
+	}
+	data, secrets, err := r.resolveSecrets(&job, data)
+	if err != nil {
+		return nil, job.error(err)
+	}
+	if len(secrets) > 0 {
+		defer func() {
+			if err != nil {
+				err = errors.New(redact(err.Error(), secrets))
+			}
+		}()
+	}
+	a := job.artifact()
+	a.secrets = secrets
+	buf := bytes.Buffer{}
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, job.error(err)
+	}
+	content := buf.Bytes()
+	if job.output.PostProcessor != "" {
+		pp, ok := r.PostProcessors[job.output.PostProcessor]
+		if !ok {
+			return nil, job.error(fmt.Errorf("post-processor %s does not exist", job.output.PostProcessor))
+		}
+		content, err = pp(content)
+		if err != nil {
+			return nil, job.error(err)
+		}
+	}
+	a.Content = content
+	a.Repository, err = renderString("repository", job.output.Repository, data)
+	if err != nil {
+		return nil, job.error(err)
+	}
+	a.Repository = r.index.repositoryName(job.namespace(), a.Repository)
+	a.File, err = renderString("file", job.output.File, data)
+	if err != nil {
+		return nil, job.error(err)
+	}
+	if err := validateOutputFile(a.File); err != nil {
+		return nil, job.error(err)
+	}
+	return a, nil
+}
+
+// parseString parses s as a text/template, or returns nil if s is not a
+// template. Missing keys are errors rather than rendering as "<no value>".
+func parseString(name string, s string) (*template.Template, error) {
+	if !strings.Contains(s, "{{") {
+		return nil, nil
+	}
+	return template.New(name).Option("missingkey=error").Parse(s)
+}
+
+// renderString renders s as a text/template with data, such as the file and
+// repository of an output.
+func renderString(name string, s string, data interface{}) (string, error) {
+	t, err := parseString(name, s)
+	if err != nil || t == nil {
+		return s, err
+	}
+	buf := strings.Builder{}
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// defaultContext returns a DefaultContext for the Index with no Self.
+func (i *Index) defaultContext() *v1.DefaultContext {
+	c := &v1.DefaultContext{
+		Resources:    map[string]map[string]*v1.ResourceSpec{},
+		Templates:    map[string]*v1.TemplateSpec{},
+		Repositories: map[string]*v1.RepositorySpec{},
+		Namespaces:   map[string]*v1.NamespaceContext{},
+	}
+	// namespace returns the context of the namespace ns
+	namespace := func(ns string) *v1.NamespaceContext {
+		n, ok := c.Namespaces[ns]
+		if !ok {
+			n = &v1.NamespaceContext{
+				Resources:    map[string]map[string]*v1.ResourceSpec{},
+				Templates:    map[string]*v1.TemplateSpec{},
+				Repositories: map[string]*v1.RepositorySpec{},
+			}
+			c.Namespaces[ns] = n
+		}
+		return n
+	}
+	namespace("")
+	for kind, resources := range i.resourceByKind {
+		c.Resources[kind] = map[string]*v1.ResourceSpec{}
+		for name, r := range resources {
+			c.Resources[kind][name] = &r.Resource
+			n := namespace(r.Resource.Namespace)
+			if n.Resources[kind] == nil {
+				n.Resources[kind] = map[string]*v1.ResourceSpec{}
+			}
+			n.Resources[kind][r.Resource.Name] = &r.Resource
+		}
+	}
+	for name, t := range i.template {
+		c.Templates[name] = &t.Template
+		namespace(t.Template.Namespace).Templates[t.Template.Name] = &t.Template
+	}
+	for name, r := range i.repository {
+		c.Repositories[name] = &r.Repository
+		namespace(r.Repository.Namespace).Repositories[r.Repository.Name] = &r.Repository
+	}
+	return c
+}
+
+// aggregateSpecMap returns the aggregate spec as a map keyed by the YAML field
+// names, in the same way as resourceSpecMap.
+func aggregateSpecMap(spec *v1.AggregateSpec) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        spec.Name,
+		"labels":      spec.Labels,
+		"annotations": spec.Annotations,
+		"outputs":     spec.Outputs,
+	}
+}
+
+// resourceSpecMap returns the resource spec as a map keyed by the YAML field
+// names, so that templates can refer to `.Self.name`, `.Self.data` etc.
+func resourceSpecMap(spec *v1.ResourceSpec) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        spec.Name,
+		"kind":        spec.Kind,
+		"namespace":   spec.Namespace,
+		"labels":      spec.Labels,
+		"annotations": spec.Annotations,
+		"data":        spec.Data,
+		"outputs":     spec.Outputs,
+		"dependsOn":   spec.DependsOn,
+		"computed":    spec.Computed,
+	}
+}
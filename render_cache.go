@@ -0,0 +1,410 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// renderCacheVersion is the version of the format of a RenderCache. It is
+// part of every key, so that entries of another version are never used.
+const renderCacheVersion = 1
+
+// RenderCache is a cache of rendered artifacts persisted in a directory. An
+// artifact is keyed by a hash of everything that goes into rendering its
+// output, so that a Renderer with a cache only renders the outputs whose
+// inputs changed since they were cached. Each artifact is stored in its own
+// file, named by its key, so that a render only writes the artifacts it
+// rendered and removes those it no longer uses. It is safe for concurrent
+// use.
+type RenderCache struct {
+	dir string
+	mu  sync.Mutex
+	// entries are the artifacts read from or added to the cache, keyed by
+	// key.
+	entries map[string]*renderCacheEntry
+	// stored is the set of keys with an entry stored in dir.
+	stored map[string]bool
+	// used is the set of keys looked up or added since the last render
+	// began.
+	used map[string]bool
+	// hashes is the hash of every spec hashed since the last render began,
+	// keyed by the pointer to the document it belongs to, and previous is
+	// that of the render before. Documents are replaced rather than modified
+	// in an Index, so a document is only hashed in the render after it is
+	// added, except for resources with computed fields, whose data Compute
+	// sets in place and which are hashed in every render.
+	hashes   map[interface{}]string
+	previous map[interface{}]string
+}
+
+// renderCacheEntry is a cached artifact.
+type renderCacheEntry struct {
+	Repository string `json:"repository"`
+	File       string `json:"file"`
+	Content    []byte `json:"content"`
+}
+
+// OpenRenderCache opens the cache persisted in the directory dir, creating
+// the directory if it does not exist. Entries are read when they are first
+// looked up, and an entry that cannot be read is rendered again rather than
+// returned as an error.
+func OpenRenderCache(dir string) (*RenderCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &RenderCache{
+		dir:      dir,
+		entries:  map[string]*renderCacheEntry{},
+		stored:   map[string]bool{},
+		used:     map[string]bool{},
+		hashes:   map[interface{}]string{},
+		previous: map[interface{}]string{},
+	}
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			c.stored[strings.TrimSuffix(f.Name(), ".json")] = true
+		}
+	}
+	return c, nil
+}
+
+// get returns the artifact cached under key, with only its repository, file
+// and content set, or nil if there is none.
+func (c *RenderCache) get(key string) *Artifact {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		if !c.stored[key] {
+			return nil
+		}
+		b, err := ioutil.ReadFile(c.entryPath(key))
+		e = &renderCacheEntry{}
+		if err != nil || json.Unmarshal(b, e) != nil {
+			// the entry is missing or corrupt, so render the output again
+			return nil
+		}
+		c.entries[key] = e
+	}
+	c.used[key] = true
+	return &Artifact{Repository: e.Repository, File: e.File, Content: append([]byte{}, e.Content...)}
+}
+
+// put caches the artifact under key.
+func (c *RenderCache) put(key string, a *Artifact) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &renderCacheEntry{
+		Repository: a.Repository,
+		File:       a.File,
+		Content:    append([]byte{}, a.Content...),
+	}
+	// the stored entry, if any, could not be read
+	delete(c.stored, key)
+	c.used[key] = true
+}
+
+// begin marks the start of a render.
+func (c *RenderCache) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used = map[string]bool{}
+	c.previous, c.hashes = c.hashes, map[interface{}]string{}
+}
+
+// specHash returns the hash of the spec of the document doc, which must be a
+// pointer, hashing it only if it was not hashed in the previous render or is
+// a resource with computed fields.
+func (c *RenderCache) specHash(doc interface{}, spec interface{}) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.hashes[doc]; ok {
+		return h, nil
+	}
+	h, ok := c.previous[doc]
+	if r, isResource := doc.(*v1.Resource); isResource && len(r.Resource.Computed) > 0 {
+		ok = false
+	}
+	if !ok {
+		b, err := yaml.Marshal(spec)
+		if err != nil {
+			return "", err
+		}
+		h = contentHash(string(b))
+	}
+	c.hashes[doc] = h
+	return h, nil
+}
+
+// save stores the entries added since the render began, and forgets those
+// that were not used, which are the entries of outputs whose inputs changed
+// or that no longer exist.
+func (c *RenderCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if !c.used[key] {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.stored {
+		if !c.used[key] {
+			if err := os.Remove(c.entryPath(key)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			delete(c.stored, key)
+		}
+	}
+	for key, e := range c.entries {
+		if c.stored[key] {
+			continue
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(c.entryPath(key), b); err != nil {
+			return err
+		}
+		c.stored[key] = true
+	}
+	return nil
+}
+
+// entryPath returns the path of the entry with the key.
+func (c *RenderCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it to path, so that path is never left partially written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// templateInputs are the inputs of the outputs of a template, besides the
+// specs they are rendered for.
+type templateInputs struct {
+	// hash covers the content and function libraries of every template the
+	// template is compiled from.
+	hash string
+	// refs are the resources the templates refer to in the context.
+	refs []v1.ReferenceSpec
+	// all is true if the templates may refer to any part of the context.
+	all bool
+}
+
+// cacheKeys returns the cache key of each job, or "" for a job that cannot be
+// cached. The key is a hash of the specs of the resource, generator or
+// aggregate of the job and of the resources selected by the aggregate, the
+// name of the output, the templates it is compiled from, the resources they
+// and the templated repository and file of the output refer to in the
+// context, or the whole context if they may refer to any of it, and the
+// Version of the Renderer. The post-processor is covered by its name in the
+// output spec and the Version.
+func (r *Renderer) cacheKeys(jobs []renderJob) ([]string, error) {
+	i := r.index
+	c := r.Cache
+	// templates are the inputs of each template, or nil if it cannot be
+	// compiled
+	templates := map[string]*templateInputs{}
+	context := ""
+	// repositories are the names of the repositories of each namespace, which
+	// the repository of an output is looked up in
+	repositories := map[string]string{}
+	keys := make([]string, len(jobs))
+	for n, job := range jobs {
+		name := i.templateName(job.namespace(), job.output.Template)
+		t, ok := templates[name]
+		if !ok {
+			t = i.templateInputs(name)
+			templates[name] = t
+		}
+		if t == nil {
+			continue
+		}
+
+		h := strings.Builder{}
+		write := func(field string, value string) {
+			h.WriteString(field)
+			h.WriteByte(' ')
+			h.WriteString(value)
+			h.WriteByte('\n')
+		}
+		write("cache", strconv.Itoa(renderCacheVersion))
+		write("version", r.Version)
+		if r.MissingKeyError {
+			write("missingkey", "error")
+		}
+		write("output", job.output.Name)
+		write("templates", t.hash)
+		if ns := job.namespace(); ns != "" {
+			if _, ok := repositories[ns]; !ok {
+				repositories[ns] = i.namespaceRepositories(ns)
+			}
+			write("repositories", repositories[ns])
+		}
+		docs := []interface{}{}
+		specs := []interface{}{}
+		// expanded are the resources whose data is expanded in the context
+		expanded := []*v1.Resource{}
+		if job.resource != nil {
+			docs, specs = append(docs, job.resource), append(specs, &job.resource.Resource)
+			expanded = append(expanded, job.resource)
+		}
+		if job.generator != nil {
+			docs, specs = append(docs, job.generator), append(specs, &job.generator.Generator)
+		}
+		if job.aggregate != nil {
+			docs, specs = append(docs, job.aggregate), append(specs, &job.aggregate.Aggregate)
+			for _, m := range job.members {
+				docs, specs = append(docs, m), append(specs, &m.Resource)
+			}
+			expanded = append(expanded, job.members...)
+		}
+		// the resources their data refers to are expanded in the context too
+		referenced, missing := i.referenceClosure(expanded)
+		if anySecrets(append(expanded, referenced...)) || i.anyDecrypted(append(expanded, referenced...)) {
+			// secrets are resolved when rendering, so the cache would neither
+			// see them change nor keep them out of its files, and decrypted
+			// values must not be written to its files either
+			continue
+		}
+		for _, res := range referenced {
+			docs, specs = append(docs, res), append(specs, &res.Resource)
+		}
+		for _, ref := range missing {
+			write("missing", ref.Kind+"/"+qualifiedName(ref.Namespace, ref.Name))
+		}
+		// the repository and file of the output may refer to resources too
+		outRefs, outAll := outputRefs(job.output)
+		refs := append(append([]v1.ReferenceSpec{}, t.refs...), outRefs...)
+		read := []*v1.Resource{}
+		for _, ref := range refs {
+			if res := i.GetResource(ref.Kind, ref.Name); res != nil {
+				docs, specs = append(docs, res), append(specs, &res.Resource)
+				read = append(read, res)
+			} else {
+				write("missing", ref.Kind+"/"+ref.Name)
+			}
+		}
+		if i.anyDecrypted(read) || ((t.all || outAll) && i.hasDecrypted()) {
+			// the context read by the templates has decrypted values
+			continue
+		}
+		for d := range docs {
+			spec, err := c.specHash(docs[d], specs[d])
+			if err != nil {
+				return nil, err
+			}
+			write("spec", spec)
+		}
+		if t.all || outAll {
+			if context == "" {
+				var err error
+				if context, err = r.contextHash(); err != nil {
+					return nil, err
+				}
+			}
+			write("context", context)
+		}
+		keys[n] = contentHash(h.String())
+	}
+	return keys, nil
+}
+
+// templateInputs returns the inputs of the outputs of the named template, or
+// nil if it cannot be compiled.
+func (i *Index) templateInputs(name string) *templateInputs {
+	if _, err := i.parsedTemplate(name); err != nil {
+		return nil
+	}
+	t := &templateInputs{}
+	h := strings.Builder{}
+	for _, d := range i.templates.deps(name) {
+		spec := i.lookupTemplate(d)
+		if spec == nil {
+			continue
+		}
+		fmt.Fprintf(&h, "%s %s %s;", d, templateHash(spec), strings.Join(spec.Functions, ","))
+		refs, all := contextRefs(spec)
+		t.refs = append(t.refs, refs...)
+		t.all = t.all || all
+	}
+	t.hash = h.String()
+	return t
+}
+
+// namespaceRepositories returns the sorted names of the repositories in the
+// namespace, separated by commas.
+func (i *Index) namespaceRepositories(namespace string) string {
+	names := []string{}
+	for _, r := range i.repository {
+		if r.Repository.Namespace == namespace {
+			names = append(names, r.Repository.Name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// contextHash returns the hash of the whole context of the Index, besides
+// Self and Selected.
+func (r *Renderer) contextHash() (string, error) {
+	i := r.index
+	h := strings.Builder{}
+	for _, kind := range sortedKeys(i.resourceByKind) {
+		for _, name := range sortedResourceNames(i.resourceByKind[kind]) {
+			res := i.resourceByKind[kind][name]
+			spec, err := r.Cache.specHash(res, &res.Resource)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&h, "resource %s/%s %s\n", kind, name, spec)
+		}
+	}
+	for _, name := range sortedTemplateNames(i.template) {
+		t := i.template[name]
+		spec, err := r.Cache.specHash(t, &t.Template)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&h, "template %s %s\n", name, spec)
+	}
+	for _, name := range sortedRepositoryNames(i.repository) {
+		repo := i.repository[name]
+		spec, err := r.Cache.specHash(repo, &repo.Repository)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&h, "repository %s %s\n", name, spec)
+	}
+	return contentHash(h.String()), nil
+}
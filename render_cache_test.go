@@ -0,0 +1,412 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// cachedRenderer is a helper function that returns a Renderer for the Index
+// with a cache in dir, and a function returning the outputs rendered since it
+// was last called, counted by a post-processor.
+func cachedRenderer(t *testing.T, i *Index, dir string) (*Renderer, func() []string) {
+	c, err := OpenRenderCache(dir)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	mu := sync.Mutex{}
+	rendered := []string{}
+	r.PostProcessors["count"] = func(content []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		rendered = append(rendered, strings.SplitN(string(content), ":", 2)[0])
+		return content, nil
+	}
+	return r, func() []string {
+		r, errs := r.Render(context.Background())
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		if len(r) != 4 {
+			t.Fatalf("Expected 4 artifacts, got %d", len(r))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done := rendered
+		rendered = []string{}
+		return done
+	}
+}
+
+// cacheIndex is a helper function that returns an Index with three
+// resources, one of which reads another through the context, and an
+// aggregate of two of them.
+func cacheIndex(t *testing.T) *Index {
+	i := NewIndex()
+	for _, tpl := range []v1.TemplateSpec{
+		{Name: "self", Content: `{{ .Self.name }}:{{ include "port" . }}`},
+		{Name: "port", Content: `{{ .Self.data.port }}`},
+		{Name: "reads", Content: `{{ .Self.name }}:{{ .Resources.database.orders.Data.port }}`},
+		{Name: "list", Content: `list:{{ range .Selected }}{{ .name }} {{ end }}`},
+	} {
+		if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: tpl}); err != nil {
+			t.Fatalf("Failed to add template: %s", err)
+		}
+	}
+	for _, r := range []v1.ResourceSpec{
+		{Name: "orders", Kind: "database", Labels: map[string]string{"list": "yes"}, Data: map[string]interface{}{"port": 5432}},
+		{Name: "users", Kind: "database", Data: map[string]interface{}{"port": 5433}},
+		{Name: "orders", Kind: "service", Labels: map[string]string{"list": "yes"}},
+	} {
+		template := "self"
+		if r.Kind == "service" {
+			template = "reads"
+		}
+		r.Outputs = []v1.OutputSpec{{Name: "config", Repository: "repo-1", File: r.Kind + "/" + r.Name, Template: template, PostProcessor: "count"}}
+		if err := i.AddResource(&v1.Resource{APIVersion: "v1", Resource: r}); err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	err := i.AddAggregate(&v1.Aggregate{
+		APIVersion: "v1",
+		Aggregate: v1.AggregateSpec{
+			Name: "list",
+			Selectors: []v1.SelectorSpec{
+				{Kind: "database", Labels: map[string]string{"list": "yes"}},
+				{Kind: "service", Labels: map[string]string{"list": "yes"}},
+			},
+			Outputs: []v1.OutputSpec{{Name: "list", Repository: "repo-1", File: "list", Template: "list", PostProcessor: "count"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add aggregate: %s", err)
+	}
+	return i
+}
+
+// replaceResource is a helper function that replaces a resource of the Index
+// with a copy modified by fn, which must not modify the maps of the copy in
+// place.
+func replaceResource(t *testing.T, i *Index, kind string, name string, fn func(*v1.ResourceSpec)) {
+	old := i.GetResource(kind, name)
+	r := &v1.Resource{APIVersion: old.APIVersion, Resource: old.Resource}
+	fn(&r.Resource)
+	if err := i.RemoveResource(old); err != nil {
+		t.Fatalf("Failed to remove resource: %s", err)
+	}
+	if err := i.AddResource(r); err != nil {
+		t.Fatalf("Failed to add resource: %s", err)
+	}
+}
+
+// replaceTemplate is a helper function that replaces the content of a
+// template of the Index.
+func replaceTemplate(t *testing.T, i *Index, name string, content string) {
+	if err := i.RemoveTemplate(&v1.Template{Template: v1.TemplateSpec{Name: name}}); err != nil {
+		t.Fatalf("Failed to remove template: %s", err)
+	}
+	if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: name, Content: content}}); err != nil {
+		t.Fatalf("Failed to add template: %s", err)
+	}
+}
+
+// expectRendered is a helper function that fails the test if the rendered
+// outputs are not the expected ones.
+func expectRendered(t *testing.T, step string, expected []string, rendered []string) {
+	t.Helper()
+	got := map[string]bool{}
+	for _, r := range rendered {
+		got[r] = true
+	}
+	if len(rendered) != len(expected) {
+		t.Errorf("%s: expected %v to be rendered, got %v", step, expected, rendered)
+		return
+	}
+	for _, e := range expected {
+		if !got[e] {
+			t.Errorf("%s: expected %v to be rendered, got %v", step, expected, rendered)
+			return
+		}
+	}
+}
+
+// Test_Renderer_Render_Cache tests the Render function of the Renderer with
+// a Cache. It expects only the outputs whose inputs changed to be rendered
+// again: those of a modified resource, of the resources that read it, of the
+// aggregates that select it and of a modified template or partial.
+func Test_Renderer_Render_Cache(t *testing.T) {
+	i := cacheIndex(t)
+	r, render := cachedRenderer(t, i, t.TempDir())
+	expectRendered(t, "first render", []string{"orders", "users", "orders", "list"}, render())
+	expectRendered(t, "unchanged", []string{}, render())
+
+	replaceResource(t, i, "database", "users", func(spec *v1.ResourceSpec) {
+		spec.Data = map[string]interface{}{"port": 6433}
+	})
+	expectRendered(t, "resource changed", []string{"users"}, render())
+
+	// the service reads the port of the orders database, which the aggregate
+	// selects
+	replaceResource(t, i, "database", "orders", func(spec *v1.ResourceSpec) {
+		spec.Data = map[string]interface{}{"port": 6432}
+	})
+	expectRendered(t, "read resource changed", []string{"orders", "orders", "list"}, render())
+
+	replaceResource(t, i, "service", "orders", func(spec *v1.ResourceSpec) {
+		spec.Annotations = map[string]string{"owner": "team-1"}
+	})
+	expectRendered(t, "selected resource changed", []string{"orders", "list"}, render())
+	replaceResource(t, i, "database", "users", func(spec *v1.ResourceSpec) {
+		spec.Labels = map[string]string{"list": "yes"}
+	})
+	expectRendered(t, "resource selected", []string{"users", "list"}, render())
+
+	replaceTemplate(t, i, "port", `{{ .Self.data.port }} `)
+	expectRendered(t, "partial changed", []string{"orders", "users"}, render())
+	replaceTemplate(t, i, "reads", `{{ .Self.name }}:{{ .Resources.database.users.Data.port }}`)
+	expectRendered(t, "template changed", []string{"orders"}, render())
+
+	r.Version = "2"
+	expectRendered(t, "version changed", []string{"orders", "users", "orders", "list"}, render())
+}
+
+// Test_Renderer_Render_CachePersisted tests the Render function of the
+// Renderer with a Cache. It expects a cache opened again from the same
+// directory to render nothing, and the same artifacts to be returned, and
+// corrupt entries to be rendered again.
+func Test_Renderer_Render_CachePersisted(t *testing.T) {
+	dir := t.TempDir()
+	i := cacheIndex(t)
+	r, render := cachedRenderer(t, i, dir)
+	expectRendered(t, "first render", []string{"orders", "users", "orders", "list"}, render())
+	expected, _ := r.Render(context.Background())
+
+	r, render = cachedRenderer(t, i, dir)
+	expectRendered(t, "reopened", []string{}, render())
+	artifacts, _ := r.Render(context.Background())
+	for n, a := range artifacts {
+		e := expected[n]
+		if a.Kind != e.Kind || a.Resource != e.Resource || a.Aggregate != e.Aggregate || a.File != e.File || string(a.Content) != string(e.Content) {
+			t.Errorf("Expected %s %q, got %s %q", e.File, e.Content, a.File, a.Content)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 4 {
+		t.Fatalf("Expected 4 entries, got %v", files)
+	}
+	if err := ioutil.WriteFile(files[0], []byte("{"), 0644); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	_, render = cachedRenderer(t, i, dir)
+	if rendered := render(); len(rendered) != 1 {
+		t.Errorf("Expected 1 output to be rendered, got %v", rendered)
+	}
+}
+
+// Test_Renderer_Render_CacheWholeContext tests the Render function of the
+// Renderer with a Cache. It expects a template ranging over the resources of
+// the context to be rendered again when any resource changes.
+func Test_Renderer_Render_CacheWholeContext(t *testing.T) {
+	i := cacheIndex(t)
+	_, render := cachedRenderer(t, i, t.TempDir())
+	replaceTemplate(t, i, "list", `list:{{ range $k, $v := .Resources.database }}{{ $k }} {{ end }}`)
+	expectRendered(t, "first render", []string{"orders", "users", "orders", "list"}, render())
+	replaceResource(t, i, "service", "orders", func(spec *v1.ResourceSpec) {
+		spec.Labels = map[string]string{}
+	})
+	expectRendered(t, "context changed", []string{"orders", "list"}, render())
+}
+
+// Test_Renderer_Render_CacheReferences tests the Render function of the
+// Renderer with a Cache. It expects an output to be rendered again when a
+// resource referred to in the data of its resource changes.
+func Test_Renderer_Render_CacheReferences(t *testing.T) {
+	i := mustLoad(t, "testdata/054-references")
+	c, err := OpenRenderCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	for _, port := range []int{5432, 6432} {
+		replaceResource(t, i, "database", "orders", func(spec *v1.ResourceSpec) {
+			spec.Data = map[string]interface{}{"port": port}
+		})
+		artifacts, errs := r.Render(context.Background())
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		expected := fmt.Sprintf("orders orders:%d orders", port)
+		if len(artifacts) != 1 || string(artifacts[0].Content) != expected {
+			t.Errorf("Expected %q, got %v", expected, artifacts)
+		}
+	}
+}
+
+// Test_Renderer_Render_CacheMissingKeyError tests the Render function of the
+// Renderer with a Cache. It expects an output cached without MissingKeyError
+// to be rendered again, and fail, with it set.
+func Test_Renderer_Render_CacheMissingKeyError(t *testing.T) {
+	c, err := OpenRenderCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(mustLoad(t, "testdata/061-template-check"))
+	r.Cache = c
+	if _, errs := r.Render(context.Background()); len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	r.MissingKeyError = true
+	if _, errs := r.Render(context.Background()); len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+}
+
+// Test_Renderer_Render_CacheOutputFile tests the Render function of the
+// Renderer with a Cache. It expects an output to be rendered again when a
+// resource read by its templated file changes.
+func Test_Renderer_Render_CacheOutputFile(t *testing.T) {
+	i := NewIndex()
+	if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: "config", Content: "config"}}); err != nil {
+		t.Fatalf("Failed to add template: %s", err)
+	}
+	for _, spec := range []v1.ResourceSpec{
+		{Name: "db", Kind: "database", Data: map[string]interface{}{"host": "old"}},
+		{Name: "orders", Kind: "service", Outputs: []v1.OutputSpec{{Name: "config", Repository: "repo-1", File: "{{ .Resources.database.db.Data.host }}.txt", Template: "config"}}},
+	} {
+		if err := i.AddResource(&v1.Resource{APIVersion: "v1", Resource: spec}); err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	c, err := OpenRenderCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	for _, host := range []string{"old", "new"} {
+		replaceResource(t, i, "database", "db", func(spec *v1.ResourceSpec) {
+			spec.Data = map[string]interface{}{"host": host}
+		})
+		artifacts, errs := r.Render(context.Background())
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		if len(artifacts) != 1 || artifacts[0].File != host+".txt" {
+			t.Errorf("Expected %s.txt, got %v", host, artifacts)
+		}
+	}
+}
+
+// Test_Renderer_Render_CacheComputed tests the Render function of the
+// Renderer with a Cache. It expects an output reading a computed field of its
+// resource to be rendered again when the resource the field reads is replaced
+// and the fields are computed again.
+func Test_Renderer_Render_CacheComputed(t *testing.T) {
+	i := NewIndex()
+	if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: "config", Content: "{{ .Self.data.url }}"}}); err != nil {
+		t.Fatalf("Failed to add template: %s", err)
+	}
+	for _, spec := range []v1.ResourceSpec{
+		{Name: "db", Kind: "database", Data: map[string]interface{}{"host": "old.db"}},
+		{Name: "orders", Kind: "service", Computed: map[string]string{"url": "pg://{{ .Resources.database.db.Data.host }}"}, Outputs: []v1.OutputSpec{{Name: "config", Repository: "repo-1", File: "config", Template: "config"}}},
+	} {
+		if err := i.AddResource(&v1.Resource{APIVersion: "v1", Resource: spec}); err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	c, err := OpenRenderCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	for _, host := range []string{"old.db", "new.db"} {
+		replaceResource(t, i, "database", "db", func(spec *v1.ResourceSpec) {
+			spec.Data = map[string]interface{}{"host": host}
+		})
+		if errs := i.Compute(); len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		artifacts, errs := r.Render(context.Background())
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		if len(artifacts) != 1 || string(artifacts[0].Content) != "pg://"+host {
+			t.Errorf("Expected pg://%s, got %v", host, artifacts)
+		}
+	}
+}
+
+// Test_outputRefs tests the outputRefs function. It expects the resources
+// the templated repository and file of an output refer to.
+func Test_outputRefs(t *testing.T) {
+	refs, all := outputRefs(&v1.OutputSpec{Repository: "{{ .Resources.repo.a.Data.name }}", File: "{{ .Self.name }}/{{ .Resources.database.b.Name }}.txt"})
+	if len(refs) != 2 || refs[0].Name != "a" || refs[1].Name != "b" || all {
+		t.Errorf("Expected a and b, got %v and %t", refs, all)
+	}
+	if _, all := outputRefs(&v1.OutputSpec{File: "{{ range .Resources.a }}{{ .Name }}{{ end }}"}); !all {
+		t.Errorf("Expected all to be true")
+	}
+}
+
+// Test_Index_contextRefs tests the contextRefs function. It expects the
+// resources a template refers to, and all to be true if it refers to the
+// context in a way that can not be tied to specific resources.
+func Test_Index_contextRefs(t *testing.T) {
+	tests := []struct {
+		spec v1.TemplateSpec
+		refs int
+		all  bool
+	}{
+		{v1.TemplateSpec{Content: `{{ .Self.name }}{{ range .Self.data }}{{ . }}{{ end }}{{ include "x" . }}`}, 0, false},
+		{v1.TemplateSpec{Content: `{{ .Resources.a.b.Data }}{{ with $.Resources.c.d }}{{ .Name }}{{ end }}`}, 2, false},
+		{v1.TemplateSpec{Content: `{{ range .Resources.a }}{{ .Name }}{{ end }}`}, 0, true},
+		{v1.TemplateSpec{Content: `{{ .Repositories }}`}, 0, true},
+		{v1.TemplateSpec{Content: `{{ index . "Resources" }}`}, 0, true},
+		{v1.TemplateSpec{Content: `${Resources.a.b.data.port}`, Engine: "envsubst"}, 1, false},
+		{v1.TemplateSpec{Content: `${Repositories.a.url}`, Engine: "envsubst"}, 0, true},
+	}
+	for _, test := range tests {
+		refs, all := contextRefs(&test.spec)
+		if len(refs) != test.refs || all != test.all {
+			t.Errorf("Expected %d refs and %t for %s, got %v and %t", test.refs, test.all, test.spec.Content, refs, all)
+		}
+	}
+}
+
+// Benchmark_Renderer_Render_Cached benchmarks the Render function of the
+// Renderer with a Cache over a synthetic Index of 10k resources, one of which
+// changes between renders.
+func Benchmark_Renderer_Render_Cached(b *testing.B) {
+	i := syntheticIndex(b, 10000)
+	c, err := OpenRenderCache(b.TempDir())
+	if err != nil {
+		b.Fatalf("Expected nil, got %s", err)
+	}
+	r := NewRenderer(i)
+	r.Cache = c
+	if _, errs := r.Render(context.Background()); len(errs) != 0 {
+		b.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		old := i.GetResource("kind-0", "resource-00000")
+		res := &v1.Resource{APIVersion: old.APIVersion, Resource: old.Resource}
+		res.Resource.Data = map[string]interface{}{"port": n}
+		i.RemoveResource(old)
+		i.AddResource(res)
+		if _, errs := r.Render(context.Background()); len(errs) != 0 {
+			b.Fatalf("Expected 0 errors, got %v", errs)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// mustLoad is a helper function that loads an Index from dir and fails the
+// test on error. Warnings are ignored.
+func mustLoad(t testing.TB, dir string) *Index {
+	i := NewIndex()
+	if errs := i.Load(dir).Errors(); len(errs) != 0 {
+		t.Fatalf("Failed to load %s: %v", dir, errs)
+	}
+	return i
+}
+
+// Test_Renderer_Render tests the Render function of the Renderer. It expects
+// the artifacts of two resources to be rendered in sorted order.
+func Test_Renderer_Render(t *testing.T) {
+	r := NewRenderer(mustLoad(t, "testdata/028-render"))
+	r.PostProcessors["upper"] = func(content []byte) ([]byte, error) {
+		return bytes.ToUpper(content), nil
+	}
+	artifacts, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := []Artifact{
+		{Kind: "test", Resource: "resource-1", Output: "output-1", Repository: "repo-1", File: "resource-1.txt", Content: []byte("resource-1 prod 8080")},
+		{Kind: "test", Resource: "resource-1", Output: "output-2", Repository: "repo-1", File: "resource-1-upper.txt", Content: []byte("RESOURCE-1 PROD 8080")},
+		{Kind: "test", Resource: "resource-2", Output: "output-1", Repository: "repo-1", File: "resource-2.txt", Content: []byte("resource-2 dev 9090")},
+	}
+	if len(artifacts) != len(expected) {
+		t.Fatalf("Expected %d artifacts, got %d", len(expected), len(artifacts))
+	}
+	for n, a := range artifacts {
+		e := expected[n]
+		if a.Kind != e.Kind || a.Resource != e.Resource || a.Output != e.Output || a.Repository != e.Repository || a.File != e.File {
+			t.Errorf("Expected %s/%s/%s at %s:%s, got %s/%s/%s at %s:%s", e.Kind, e.Resource, e.Output, e.Repository, e.File, a.Kind, a.Resource, a.Output, a.Repository, a.File)
+		}
+		if string(a.Content) != string(e.Content) {
+			t.Errorf("Expected %q, got %q", e.Content, a.Content)
+		}
+	}
+}
+
+// Test_Renderer_Render_Errors tests the Render function of the Renderer. It
+// expects an error for each output that cannot be rendered, in sorted order.
+func Test_Renderer_Render_Errors(t *testing.T) {
+	i := NewIndex()
+	for _, name := range []string{"b", "a"} {
+		i.AddResource(&v1.Resource{
+			APIVersion: "v1",
+			Resource: v1.ResourceSpec{
+				Name: name,
+				Kind: "test",
+				Outputs: []v1.OutputSpec{
+					{Name: "missing-template", Template: "missing"},
+					{Name: "missing-post-processor", Template: "template-1", PostProcessor: "missing"},
+				},
+			},
+		})
+	}
+	i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: "template-1", Content: "test"}})
+	r := NewRenderer(i)
+	r.Workers = 4
+	artifacts, errs := r.Render(context.Background())
+	if len(artifacts) != 0 {
+		t.Errorf("Expected 0 artifacts, got %d", len(artifacts))
+	}
+	expected := []string{
+		"resource a of kind test: output missing-template: template missing does not exist",
+		"resource a of kind test: output missing-post-processor: post-processor missing does not exist",
+		"resource b of kind test: output missing-template: template missing does not exist",
+		"resource b of kind test: output missing-post-processor: post-processor missing does not exist",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("Expected %s, got %s", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Renderer_Render_Deterministic tests the Render function of the
+// Renderer. It expects the same artifacts in the same order regardless of the
+// number of workers.
+func Test_Renderer_Render_Deterministic(t *testing.T) {
+	i := syntheticIndex(t, 200)
+	r := NewRenderer(i)
+	r.Workers = 1
+	serial, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	r.Workers = 16
+	parallel, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(serial) != len(parallel) {
+		t.Fatalf("Expected %d artifacts, got %d", len(serial), len(parallel))
+	}
+	for n := range serial {
+		if serial[n].File != parallel[n].File || string(serial[n].Content) != string(parallel[n].Content) {
+			t.Errorf("Expected %s at %d, got %s", serial[n].File, n, parallel[n].File)
+		}
+	}
+}
+
+// Test_Renderer_Render_Cancelled tests the Render function of the Renderer.
+// It expects a context cancelled before rendering to render nothing, and to
+// be reported.
+func Test_Renderer_Render_Cancelled(t *testing.T) {
+	r := NewRenderer(syntheticIndex(t, 100))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for n := 0; n < 20; n++ {
+		artifacts, errs := r.Render(ctx)
+		if len(artifacts) != 0 {
+			t.Fatalf("Expected 0 artifacts, got %d", len(artifacts))
+		}
+		found := false
+		for _, err := range errs {
+			found = found || errors.Is(err, context.Canceled)
+		}
+		if !found {
+			t.Fatalf("Expected %s, got %v", context.Canceled, errs)
+		}
+	}
+}
+
+// Test_Renderer_Render_DuplicateFile tests the Render function of the
+// Renderer. It expects an error naming both outputs for an output rendered to
+// the same file of the same repository as another, and its artifact not to be
+// returned.
+func Test_Renderer_Render_DuplicateFile(t *testing.T) {
+	i := NewIndex()
+	if err := i.AddTemplate(&v1.Template{APIVersion: "v1", Template: v1.TemplateSpec{Name: "config", Content: "{{ .Self.name }}"}}); err != nil {
+		t.Fatalf("Failed to add template: %s", err)
+	}
+	for _, name := range []string{"billing", "orders"} {
+		spec := v1.ResourceSpec{Name: name, Kind: "service", Outputs: []v1.OutputSpec{{Name: "config", Repository: "repo-1", File: "config.yaml", Template: "config"}}}
+		if err := i.AddResource(&v1.Resource{APIVersion: "v1", Resource: spec}); err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "billing" {
+		t.Errorf("Expected the artifact of billing, got %v", artifacts)
+	}
+	expected := "resource orders of kind service: output config: file config.yaml of repository repo-1 is also rendered by output:service/billing/config"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, errs)
+	}
+}
+
+// syntheticIndex returns an Index with n resources with two outputs each.
+func syntheticIndex(t testing.TB, n int) *Index {
+	i := NewIndex()
+	err := i.AddTemplate(&v1.Template{
+		APIVersion: "v1",
+		Template: v1.TemplateSpec{
+			Name:    "template-1",
+			Content: "name: {{ .Self.name }}\nkind: {{ .Self.kind }}\n{{ range $k, $v := .Self.data }}{{ $k }}: {{ $v }}\n{{ end }}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add template: %s", err)
+	}
+	for r := 0; r < n; r++ {
+		name := fmt.Sprintf("resource-%05d", r)
+		err := i.AddResource(&v1.Resource{
+			APIVersion: "v1",
+			Resource: v1.ResourceSpec{
+				Name:   name,
+				Kind:   fmt.Sprintf("kind-%d", r%10),
+				Labels: map[string]string{"env": "prod"},
+				Data:   map[string]interface{}{"port": r, "replicas": r % 5},
+				Outputs: []v1.OutputSpec{
+					{Name: "output-1", Repository: "repo-1", File: name + "-1.yaml", Template: "template-1"},
+					{Name: "output-2", Repository: "repo-1", File: name + "-2.yaml", Template: "template-1"},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to add resource: %s", err)
+		}
+	}
+	return i
+}
+
+// Benchmark_Renderer_Render benchmarks the Render function of the Renderer
+// over a synthetic Index of 10k resources.
+func Benchmark_Renderer_Render(b *testing.B) {
+	r := NewRenderer(syntheticIndex(b, 10000))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, errs := r.Render(context.Background()); len(errs) != 0 {
+			b.Fatalf("Expected 0 errors, got %v", errs)
+		}
+	}
+}
+
+// Test_Renderer_Render_Generator tests the Render function of the Renderer.
+// It expects one artifact for each resource selected by a generator, at the
+// path rendered from the file template.
+func Test_Renderer_Render_Generator(t *testing.T) {
+	artifacts, errs := NewRenderer(mustLoad(t, "testdata/037-generator")).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 2 {
+		t.Fatalf("Expected 2 artifacts, got %d", len(artifacts))
+	}
+	for n, name := range []string{"service-1", "service-3"} {
+		a := artifacts[n]
+		if a.Generator != "deploy" || a.Resource != name || a.Output != "deployment" {
+			t.Errorf("Expected deploy/%s/deployment, got %s/%s/%s", name, a.Generator, a.Resource, a.Output)
+		}
+		if a.File != "services/"+name+"/deploy.yaml" {
+			t.Errorf("Expected services/%s/deploy.yaml, got %s", name, a.File)
+		}
+	}
+	if string(artifacts[1].Content) != "replicas: 3" {
+		t.Errorf("Expected replicas: 3, got %s", artifacts[1].Content)
+	}
+}
+
+// Test_Renderer_Render_TemplatedOutput tests the Render function of the
+// Renderer. It expects the file and repository of outputs to be rendered, and
+// errors for files outside of the repository or missing keys.
+func Test_Renderer_Render_TemplatedOutput(t *testing.T) {
+	artifacts, errs := NewRenderer(mustLoad(t, "testdata/039-templated-output")).Render(context.Background())
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, got %d", len(artifacts))
+	}
+	if artifacts[0].Repository != "config-prod" {
+		t.Errorf("Expected config-prod, got %s", artifacts[0].Repository)
+	}
+	if artifacts[0].File != "clusters/eu-1/resource-1.yaml" {
+		t.Errorf("Expected clusters/eu-1/resource-1.yaml, got %s", artifacts[0].File)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	if errs[0].Error() != "resource resource-1 of kind test: output output-2: file ../../etc/passwd is outside of the repository" {
+		t.Errorf("Expected file outside of the repository error, got %s", errs[0])
+	}
+	if !strings.HasPrefix(errs[1].Error(), "resource resource-1 of kind test: output output-3: template: file:1:") {
+		t.Errorf("Expected missing key error, got %s", errs[1])
+	}
+}
+
+// Test_Renderer_Render_Aggregate tests the Render function of the Renderer.
+// It expects an aggregate output to be rendered once with all the selected
+// resources, and to include a selected resource added to the Index.
+func Test_Renderer_Render_Aggregate(t *testing.T) {
+	i := mustLoad(t, "testdata/041-aggregate")
+	r := NewRenderer(i)
+	artifacts, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, got %d", len(artifacts))
+	}
+	a := artifacts[0]
+	if a.Aggregate != "codeowners" || a.Kind != "" || a.Resource != "" || a.File != ".github/CODEOWNERS" {
+		t.Errorf("Expected codeowners at .github/CODEOWNERS, got %s/%s/%s at %s", a.Aggregate, a.Kind, a.Resource, a.File)
+	}
+	if string(a.Content) != "# owned by platform\nservices/service-1/ @team-1\nservices/service-2/ @team-2\n" {
+		t.Errorf("Expected codeowners content, got %q", a.Content)
+	}
+	err := i.AddResource(&v1.Resource{
+		APIVersion: "v1",
+		Resource: v1.ResourceSpec{
+			Name:   "service-0",
+			Kind:   "service",
+			Labels: map[string]string{"team": "team-0"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	artifacts, errs = r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if string(artifacts[0].Content) != "# owned by platform\nservices/service-0/ @team-0\nservices/service-1/ @team-1\nservices/service-2/ @team-2\n" {
+		t.Errorf("Expected codeowners content with service-0, got %q", artifacts[0].Content)
+	}
+}
+
+// Test_Renderer_Render_Namespaces tests the Render function of the Renderer.
+// It expects the templates and repositories of outputs to be looked up in the
+// namespace of their resource before the default namespace, and the context
+// to expose the documents of each namespace.
+func Test_Renderer_Render_Namespaces(t *testing.T) {
+	i := mustLoad(t, "testdata/052-namespaces")
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := []struct {
+		namespace  string
+		repository string
+		content    string
+	}{
+		{"", "repo-1", "team-a billing 5432"},
+		{"team-a", "team-a/repo-1", "team-a orders 6432"},
+		{"team-b", "repo-1", "default orders"},
+	}
+	if len(artifacts) != len(expected) {
+		t.Fatalf("Expected %d artifacts, got %d", len(expected), len(artifacts))
+	}
+	for n, e := range expected {
+		a := artifacts[n]
+		if a.Namespace != e.namespace || a.Repository != e.repository || string(a.Content) != e.content {
+			t.Errorf("Expected %s %s %q, got %s %s %q", e.namespace, e.repository, e.content, a.Namespace, a.Repository, a.Content)
+		}
+	}
+}
+
+// Test_Renderer_Render_References tests the Render function of the Renderer.
+// It expects the references in the data of a resource to be expanded to the
+// resources they refer to, and a reference cycle to be an error.
+func Test_Renderer_Render_References(t *testing.T) {
+	i := mustLoad(t, "testdata/054-references")
+	artifacts, errs := NewRenderer(i).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, got %d", len(artifacts))
+	}
+	if string(artifacts[0].Content) != "orders orders:5432 orders" {
+		t.Errorf("Expected %q, got %q", "orders orders:5432 orders", artifacts[0].Content)
+	}
+
+	replaceResource(t, i, "database", "orders", func(spec *v1.ResourceSpec) {
+		spec.Data = map[string]interface{}{
+			"service": map[string]interface{}{v1.RefKey: map[string]interface{}{"kind": "service", "name": "orders"}},
+		}
+	})
+	_, errs = NewRenderer(i).Render(context.Background())
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "reference cycle through resource orders of kind service") {
+		t.Errorf("Expected reference cycle error, got %s", errs[0])
+	}
+}
+
+// Test_Renderer_Render_MissingKeyError tests the Render function of the
+// Renderer. It expects fields missing from the data to render as
+// "<no value>", and to fail the output with MissingKeyError set.
+func Test_Renderer_Render_MissingKeyError(t *testing.T) {
+	r := NewRenderer(mustLoad(t, "testdata/061-template-check"))
+	artifacts, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 2 || string(artifacts[0].Content) != "# billing (<no value>) orders.db\nport=<no value>" {
+		t.Fatalf("Expected billing to render with <no value>, got %v", artifacts)
+	}
+
+	r.MissingKeyError = true
+	artifacts, errs = r.Render(context.Background())
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "resource billing of kind service: output config: ") || !strings.Contains(errs[0].Error(), "map has no entry for key") {
+		t.Fatalf("Expected a missing key error for billing, got %v", errs)
+	}
+	if len(artifacts) != 1 || string(artifacts[0].Content) != "# orders (checkout) orders.db\nport=8080" {
+		t.Errorf("Expected orders to render, got %v", artifacts)
+	}
+}
@@ -0,0 +1,409 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// SecretProvider resolves the secrets referred to in the data of resources
+// when they are rendered. It must be safe for concurrent use.
+type SecretProvider interface {
+	// Secret returns the value of the secret under key.
+	Secret(key string) (string, error)
+}
+
+// EnvSecretProvider resolves secrets from the environment variables named by
+// their key, prefixed with Prefix.
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+// Secret implements SecretProvider
+func (p EnvSecretProvider) Secret(key string) (string, error) {
+	value, ok := os.LookupEnv(p.Prefix + key)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", p.Prefix+key)
+	}
+	return value, nil
+}
+
+// FileSecretProvider resolves secrets from the files in Dir, at the slash
+// separated path of their key. A trailing newline is not part of a secret.
+type FileSecretProvider struct {
+	Dir string
+}
+
+// Secret implements SecretProvider
+func (p FileSecretProvider) Secret(key string) (string, error) {
+	if path.IsAbs(key) || path.Clean(key) != key || key == "." || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("key %s must be a clean relative path", key)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(p.Dir, filepath.FromSlash(key)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// EncryptedFileSecretProvider resolves secrets from the YAML file at Path,
+// which maps keys to values encrypted by EncryptSecret with Key. The file can
+// be committed alongside the model while the key is held locally.
+type EncryptedFileSecretProvider struct {
+	Path string
+	Key  []byte
+}
+
+// Secret implements SecretProvider
+func (p EncryptedFileSecretProvider) Secret(key string) (string, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return "", err
+	}
+	secrets := map[string]string{}
+	if err := yaml.Unmarshal(b, &secrets); err != nil {
+		return "", fmt.Errorf("%s: %s", p.Path, err)
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", fmt.Errorf("%s: secret %s does not exist", p.Path, key)
+	}
+	value, err = DecryptSecret(p.Key, value)
+	if err != nil {
+		return "", fmt.Errorf("%s: secret %s: %s", p.Path, key, err)
+	}
+	return value, nil
+}
+
+// SecretKeySize is the size in bytes of the keys of EncryptSecret, which
+// encrypts with AES-256.
+const SecretKeySize = 32
+
+// NewSecretKey returns a new random key for EncryptSecret.
+func NewSecretKey() ([]byte, error) {
+	key := make([]byte, SecretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ReadSecretKey reads a key for EncryptSecret from the file at path, which
+// holds it base64 encoded.
+func ReadSecretKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid key: %s", path, err)
+	}
+	if len(key) != SecretKeySize {
+		return nil, fmt.Errorf("%s: invalid key: expected %d bytes, got %d", path, SecretKeySize, len(key))
+	}
+	return key, nil
+}
+
+// encryptedPrefix and encryptedSuffix enclose the fields of an encrypted
+// value.
+const (
+	encryptedPrefix = "ENC[AES256_GCM,"
+	encryptedSuffix = "]"
+)
+
+// encryptedTypes are the YAML types of the scalars that are encrypted with
+// their type, which is restored when they are decrypted. Other scalars are
+// encrypted as strings.
+var encryptedTypes = []string{"str", "int", "float", "bool"}
+
+// EncryptSecret encrypts value with AES-256-GCM and key, and returns it as
+// `ENC[AES256_GCM,data:<data>,type:str]`, where data is the base64 encoded
+// nonce and ciphertext.
+func EncryptSecret(key []byte, value string) (string, error) {
+	return encryptTyped(key, value, "str")
+}
+
+// DecryptSecret decrypts a value encrypted by EncryptSecret with key. Values
+// of other types are returned as they are written in YAML.
+func DecryptSecret(key []byte, value string) (string, error) {
+	plain, _, err := decryptTyped(key, value)
+	return plain, err
+}
+
+// encryptTyped encrypts value, a YAML scalar of the type typ, which must be
+// one of encryptedTypes, as EncryptSecret does. The type is authenticated
+// along with the ciphertext, so that it can not be changed either.
+func encryptTyped(key []byte, value string, typ string) (string, error) {
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(typ))
+	return encryptedPrefix + "data:" + base64.StdEncoding.EncodeToString(sealed) + ",type:" + typ + encryptedSuffix, nil
+}
+
+// decryptTyped decrypts a value encrypted by encryptTyped with key, and
+// returns it with its type.
+func decryptTyped(key []byte, value string) (string, string, error) {
+	if !IsEncrypted(value) {
+		return "", "", fmt.Errorf("value is not encrypted")
+	}
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	fields := map[string]string{}
+	for _, f := range strings.Split(value[len(encryptedPrefix):len(value)-len(encryptedSuffix)], ",") {
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) != 2 {
+			return "", "", fmt.Errorf("invalid encrypted value")
+		}
+		fields[kv[0]] = kv[1]
+	}
+	typ := fields["type"]
+	sealed, err := base64.StdEncoding.DecodeString(fields["data"])
+	if err != nil || len(sealed) < gcm.NonceSize() || !containsString(encryptedTypes, typ) {
+		return "", "", fmt.Errorf("invalid encrypted value")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(typ))
+	if err != nil {
+		return "", "", fmt.Errorf("value can not be decrypted with the key")
+	}
+	return string(plain), typ, nil
+}
+
+// IsEncrypted returns true if value is in the format of the values encrypted
+// by EncryptSecret.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) && strings.HasSuffix(value, encryptedSuffix)
+}
+
+// secretCipher returns the AES-256-GCM cipher of key.
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != SecretKeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", SecretKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// dataSecret returns the secret v refers to, if it is a `$secret` map in the
+// data of a resource, and an error if it is a `$secret` map that is not a
+// valid secret reference.
+func dataSecret(v interface{}) (*v1.SecretSpec, error) {
+	secret := singleKey(v, v1.SecretKey)
+	if secret == nil {
+		return nil, nil
+	}
+	fields, ok := stringFields(secret)
+	if !ok {
+		return nil, fmt.Errorf("invalid secret, expected provider and key")
+	}
+	for k := range fields {
+		if !containsString(v1.ValidSecretSpecFields, k) {
+			return nil, fmt.Errorf("invalid secret field `%s`", k)
+		}
+	}
+	if fields["provider"] == "" || fields["key"] == "" {
+		return nil, fmt.Errorf("invalid secret, expected provider and key")
+	}
+	return &v1.SecretSpec{Provider: fields["provider"], Key: fields["key"]}, nil
+}
+
+// validateSecrets validates the secret references in the data of the
+// resource. Their providers are those of the Renderer, so they are only
+// checked when the resource is rendered.
+func validateSecrets(r *v1.Resource) []error {
+	errs := []error{}
+	name := qualifiedName(r.Resource.Namespace, r.Resource.Name)
+	walkData(r.Resource.Data, "data", func(path string, v interface{}) bool {
+		_, err := dataSecret(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resource %s of kind %s: %s: %s", name, r.Resource.Kind, path, err))
+		}
+		return singleKey(v, v1.SecretKey) == nil
+	})
+	return errs
+}
+
+// hasSecrets returns true if there is a `$secret` map in data.
+func hasSecrets(data interface{}) bool {
+	found := false
+	walkData(data, "data", func(path string, v interface{}) bool {
+		if singleKey(v, v1.SecretKey) != nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// anySecrets returns true if there is a `$secret` map in the data of any of
+// the resources.
+func anySecrets(resources []*v1.Resource) bool {
+	for _, r := range resources {
+		if hasSecrets(r.Resource.Data) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkData calls fn with the path and value of data and of every value in
+// it, in the order of their paths, descending into a value only if fn
+// returns true.
+func walkData(data interface{}, path string, fn func(path string, v interface{}) bool) {
+	if !fn(path, data) {
+		return
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		values := map[string]interface{}{}
+		for k, e := range v {
+			keys = append(keys, fmt.Sprint(k))
+			values[fmt.Sprint(k)] = e
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkData(values[k], path+"."+k, fn)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkData(v[k], path+"."+k, fn)
+		}
+	case []interface{}:
+		for n, e := range v {
+			walkData(e, fmt.Sprintf("%s[%d]", path, n), fn)
+		}
+	}
+}
+
+// resolveSecrets returns a copy of the context of the job with the secrets
+// in the data of Self and Selected, including in the resources expanded
+// into it, replaced by their values, along with the values. The resources of
+// the context are not resolved.
+func (r *Renderer) resolveSecrets(job *renderJob, data *v1.DefaultContext) (*v1.DefaultContext, []string, error) {
+	values := []string{}
+	resolve := func(spec interface{}) (interface{}, error) {
+		m, ok := spec.(map[string]interface{})
+		if !ok || !hasSecrets(m["data"]) {
+			return spec, nil
+		}
+		resolved := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			resolved[k] = v
+		}
+		var err error
+		resolved["data"], err = r.resolveData(m["data"], "data", &values)
+		return resolved, err
+	}
+	c := *data
+	self, err := resolve(c.Self)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Self = self
+	if c.Selected != nil {
+		c.Selected = make([]interface{}, len(data.Selected))
+		for n, m := range data.Selected {
+			if c.Selected[n], err = resolve(m); err != nil {
+				member := &job.members[n].Resource
+				return nil, nil, fmt.Errorf("resource %s of kind %s: %s", qualifiedName(member.Namespace, member.Name), member.Kind, err)
+			}
+		}
+	}
+	return &c, values, nil
+}
+
+// resolveData returns a copy of data at path with its secrets replaced by
+// their values, which are appended to values.
+func (r *Renderer) resolveData(data interface{}, path string, values *[]string) (interface{}, error) {
+	secret, err := dataSecret(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if secret != nil {
+		p, ok := r.SecretProviders[secret.Provider]
+		if !ok {
+			return nil, fmt.Errorf("%s: secret provider %s does not exist", path, secret.Provider)
+		}
+		value, err := p.Secret(secret.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: secret %s of provider %s: %s", path, secret.Key, secret.Provider, err)
+		}
+		if value != "" {
+			*values = append(*values, value)
+		}
+		return value, nil
+	}
+	if !hasSecrets(data) {
+		return data, nil
+	}
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			if m[k], err = r.resolveData(e, fmt.Sprintf("%s.%v", path, k), values); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if m[k], err = r.resolveData(e, path+"."+k, values); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for n, e := range v {
+			if l[n], err = r.resolveData(e, fmt.Sprintf("%s[%d]", path, n), values); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	}
+	return data, nil
+}
+
+// redactedValue replaces the values of secrets when they are redacted.
+const redactedValue = "[REDACTED]"
+
+// redact returns s with every value of secrets replaced by [REDACTED],
+// longest first so that a secret that contains another is redacted whole.
+func redact(s string, secrets []string) string {
+	sorted := append([]string{}, secrets...)
+	sort.Slice(sorted, func(a, b int) bool { return len(sorted[a]) > len(sorted[b]) })
+	for _, secret := range sorted {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	return s
+}
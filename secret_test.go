@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// secretRenderer is a helper function that returns a Renderer for the
+// secrets testdata, with a file, an env and an encrypted provider holding
+// its secrets.
+func secretRenderer(t *testing.T) *Renderer {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "files", "db"), 0755); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "files", "db", "password"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	key, err := NewSecretKey()
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	encrypted, err := EncryptSecret(key, "api-key-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	b, _ := yaml.Marshal(map[string]string{"api/key": encrypted})
+	if err := ioutil.WriteFile(filepath.Join(dir, "secrets.yaml"), b, 0600); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	t.Setenv("TEST_DB_TOKEN", "token-1")
+
+	r := NewRenderer(mustLoad(t, "testdata/062-secrets"))
+	r.SecretProviders["file"] = FileSecretProvider{Dir: filepath.Join(dir, "files")}
+	r.SecretProviders["env"] = EnvSecretProvider{Prefix: "TEST_"}
+	r.SecretProviders["encrypted"] = EncryptedFileSecretProvider{Path: filepath.Join(dir, "secrets.yaml"), Key: key}
+	return r
+}
+
+// Test_Renderer_Render_Secrets tests the Render function of the Renderer. It
+// expects the secrets in the data of a resource, and of the resources it
+// refers to, to be resolved by their providers, to be redacted by the
+// artifact, and to be neither stored in the Index nor exported.
+func Test_Renderer_Render_Secrets(t *testing.T) {
+	r := secretRenderer(t)
+	artifacts, errs := r.Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	expected := "orders:hunter2@orders.db token-1 api-key-1"
+	if len(artifacts) != 1 || string(artifacts[0].Content) != expected {
+		t.Fatalf("Expected %q, got %v", expected, artifacts)
+	}
+	redacted := "orders:[REDACTED]@orders.db [REDACTED] [REDACTED]"
+	if got := string(artifacts[0].Redact(artifacts[0].Content)); got != redacted {
+		t.Errorf("Expected %q, got %q", redacted, got)
+	}
+
+	if _, ok := r.index.GetResource("service", "orders").Resource.Data.(map[interface{}]interface{})["password"].(string); ok {
+		t.Errorf("Expected the secret not to be stored in the Index")
+	}
+	b, err := yaml.Marshal(r.index.Export())
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	for _, secret := range []string{"hunter2", "token-1", "api-key-1"} {
+		if bytes.Contains(b, []byte(secret)) {
+			t.Errorf("Expected %s not to be exported", secret)
+		}
+	}
+}
+
+// Test_Renderer_Render_SecretErrors tests the Render function of the
+// Renderer. It expects an error for secrets whose provider does not exist or
+// fails, and the values of secrets to be redacted from errors.
+func Test_Renderer_Render_SecretErrors(t *testing.T) {
+	r := secretRenderer(t)
+	delete(r.SecretProviders, "encrypted")
+	_, errs := r.Render(context.Background())
+	expected := "resource orders of kind service: output config: data.database.data.keys[0]: secret provider encrypted does not exist"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, errs)
+	}
+
+	r = secretRenderer(t)
+	os.Unsetenv("TEST_DB_TOKEN")
+	_, errs = r.Render(context.Background())
+	expected = "resource orders of kind service: output config: data.database.data.token: secret DB_TOKEN of provider env: environment variable TEST_DB_TOKEN is not set"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, errs)
+	}
+
+	r = secretRenderer(t)
+	replaceResource(t, r.index, "service", "orders", func(spec *v1.ResourceSpec) {
+		spec.Outputs[0].PostProcessor = "fail"
+	})
+	r.PostProcessors["fail"] = func(content []byte) ([]byte, error) {
+		return nil, fmt.Errorf("invalid content %s", content)
+	}
+	_, errs = r.Render(context.Background())
+	expected = "resource orders of kind service: output config: invalid content orders:[REDACTED]@orders.db [REDACTED] [REDACTED]"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, errs)
+	}
+}
+
+// Test_Renderer_Render_CacheSecrets tests the Render function of the
+// Renderer with a Cache. It expects outputs whose context has secrets not to
+// be cached, so that they are rendered with the current value of secrets.
+func Test_Renderer_Render_CacheSecrets(t *testing.T) {
+	r := secretRenderer(t)
+	dir := t.TempDir()
+	c, err := OpenRenderCache(dir)
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	r.Cache = c
+	for _, token := range []string{"token-1", "token-2"} {
+		t.Setenv("TEST_DB_TOKEN", token)
+		artifacts, errs := r.Render(context.Background())
+		if len(errs) != 0 {
+			t.Fatalf("Expected 0 errors, got %v", errs)
+		}
+		if len(artifacts) != 1 || !strings.Contains(string(artifacts[0].Content), token) {
+			t.Errorf("Expected %s, got %v", token, artifacts)
+		}
+	}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if bytes.Contains(b, []byte("hunter2")) {
+			t.Errorf("Expected the secret not to be cached in %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+}
+
+// Test_DecryptSecret tests the DecryptSecret function. It expects the value
+// encrypted by EncryptSecret with the same key, and an error with another
+// key or a value that is not encrypted.
+func Test_DecryptSecret(t *testing.T) {
+	key, _ := NewSecretKey()
+	other, _ := NewSecretKey()
+	encrypted, err := EncryptSecret(key, "hunter2")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "hunter2") {
+		t.Errorf("Expected an encrypted value, got %s", encrypted)
+	}
+	if value, err := DecryptSecret(key, encrypted); err != nil || value != "hunter2" {
+		t.Errorf("Expected hunter2, got %q, %v", value, err)
+	}
+	if _, err := DecryptSecret(other, encrypted); err == nil || err.Error() != "value can not be decrypted with the key" {
+		t.Errorf("Expected an error, got %v", err)
+	}
+	if _, err := DecryptSecret(key, "hunter2"); err == nil || err.Error() != "value is not encrypted" {
+		t.Errorf("Expected an error, got %v", err)
+	}
+	if _, err := DecryptSecret(key[:16], encrypted); err == nil || err.Error() != "invalid key: expected 32 bytes, got 16" {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
+
+// Test_FileSecretProvider tests the Secret function of the
+// FileSecretProvider. It expects an error for keys outside of its directory.
+func Test_FileSecretProvider(t *testing.T) {
+	p := FileSecretProvider{Dir: t.TempDir()}
+	for _, key := range []string{"../password", "/etc/password", "db/../../password"} {
+		if _, err := p.Secret(key); err == nil || err.Error() != fmt.Sprintf("key %s must be a clean relative path", key) {
+			t.Errorf("Expected an error for %s, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/tpology/core/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Server is an http.Handler serving a read-only JSON API over an Index:
+//
+//	GET /health                       the status of the Index served
+//	GET /kinds                        the kinds of the resources
+//	GET /resources?kind=K&labels=L    the resources of kind K with the labels
+//	                                  L, such as `team=checkout,env=prod`
+//	GET /resources/K/NAME             the resource of kind K named NAME
+//	GET /outputs/K/NAME/OUTPUT        the output OUTPUT of the resource,
+//	                                  rendered, or that of ?generator=G
+//	GET /plans/REPOSITORY             the artifacts rendered to the repository
+//
+// Resources are served as they are exported, and the content of artifacts is
+// redacted. The outputs of an Index are rendered once, when they are first
+// requested. Errors are served as `{"error": "message"}`.
+type Server struct {
+	// Renderer returns the Renderer the outputs of an Index are rendered
+	// with, such as to add post-processors and secret providers. NewRenderer
+	// is used if it is nil.
+	Renderer func(i *Index) *Renderer
+
+	mu sync.RWMutex
+	// current is the Index served, or nil if no Index was loaded without
+	// errors.
+	current *serverIndex
+	// diagnostics are those of the last Index set, which is not served if
+	// they have errors.
+	diagnostics Diagnostics
+	mux         *http.ServeMux
+}
+
+// serverIndex is an Index served by a Server, with its outputs rendered once.
+type serverIndex struct {
+	index  *Index
+	loaded time.Time
+
+	render    sync.Once
+	artifacts []*Artifact
+	errs      []error
+}
+
+// NewServer returns a new Server, which serves no Index until one is set.
+func NewServer() *Server {
+	s := &Server{mux: http.NewServeMux()}
+	s.mux.HandleFunc("/health", s.health)
+	s.mux.HandleFunc("/kinds", s.kinds)
+	s.mux.HandleFunc("/resources", s.resources)
+	s.mux.HandleFunc("/resources/", s.resource)
+	s.mux.HandleFunc("/outputs/", s.output)
+	s.mux.HandleFunc("/plans/", s.plan)
+	return s
+}
+
+// SetIndex replaces the Index served with i, loaded with diags. If diags has
+// errors, the Index served is kept, and the diagnostics are served by
+// /health until an Index without errors is set.
+func (s *Server) SetIndex(i *Index, diags Diagnostics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diagnostics = diags
+	if !diags.HasErrors() {
+		s.current = &serverIndex{index: i, loaded: time.Now()}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// index returns the Index served, writing an error if there is none.
+func (s *Server) index(w http.ResponseWriter) *serverIndex {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.current == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("no model is loaded"))
+	}
+	return s.current
+}
+
+// rendered returns the artifacts of the outputs of the Index and the errors
+// of those that fail, rendering them the first time.
+func (s *Server) rendered(si *serverIndex) ([]*Artifact, []error) {
+	si.render.Do(func() {
+		r := NewRenderer(si.index)
+		if s.Renderer != nil {
+			r = s.Renderer(si.index)
+		}
+		si.artifacts, si.errs = r.Render(context.Background())
+	})
+	return si.artifacts, si.errs
+}
+
+// health serves the status of the Index: ok if it is the last one set,
+// stale if the last one set had errors, or unavailable if none was served
+// yet, along with the diagnostics of the last one set.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := struct {
+		Status      string      `json:"status"`
+		Loaded      *time.Time  `json:"loaded,omitempty"`
+		Diagnostics Diagnostics `json:"diagnostics"`
+	}{Status: "ok", Diagnostics: s.diagnostics}
+	if status.Diagnostics == nil {
+		status.Diagnostics = Diagnostics{}
+	}
+	code := http.StatusOK
+	switch {
+	case s.current == nil:
+		status.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	case s.diagnostics.HasErrors():
+		status.Status = "stale"
+	}
+	if s.current != nil {
+		status.Loaded = &s.current.loaded
+	}
+	writeJSON(w, code, status)
+}
+
+// kinds serves the kinds of the resources, in sorted order.
+func (s *Server) kinds(w http.ResponseWriter, r *http.Request) {
+	si := s.index(w)
+	if si == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, sortedKeys(si.index.resourceByKind))
+}
+
+// resources serves the resources of a kind with some labels, sorted by name.
+func (s *Server) resources(w http.ResponseWriter, r *http.Request) {
+	si := s.index(w)
+	if si == nil {
+		return
+	}
+	query := r.URL.Query()
+	selector := &v1.SelectorSpec{Kind: query.Get("kind"), Namespace: query.Get("namespace"), Labels: map[string]string{}}
+	if selector.Kind == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("kind is required"))
+		return
+	}
+	if labels := query.Get("labels"); labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid label %s, expected key=value", label))
+				return
+			}
+			selector.Labels[kv[0]] = kv[1]
+		}
+	}
+	resources := []interface{}{}
+	for _, res := range si.index.SelectResources(selector) {
+		v, err := resourceJSON(si.index, res)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resources = append(resources, v)
+	}
+	writeJSON(w, http.StatusOK, resources)
+}
+
+// resource serves a resource by kind and qualified name.
+func (s *Server) resource(w http.ResponseWriter, r *http.Request) {
+	si := s.index(w)
+	if si == nil {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/resources/"), "/", 2)
+	if len(parts) != 2 {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("expected /resources/KIND/NAME"))
+		return
+	}
+	res := si.index.GetResource(parts[0], parts[1])
+	if res == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("resource %s of kind %s does not exist", parts[1], parts[0]))
+		return
+	}
+	v, err := resourceJSON(si.index, res)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// output serves an output of a resource, rendered. The output is that of
+// the resource, or that of the generator given by the generator parameter.
+func (s *Server) output(w http.ResponseWriter, r *http.Request) {
+	si := s.index(w)
+	if si == nil {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/outputs/")
+	slash, last := strings.Index(path, "/"), strings.LastIndex(path, "/")
+	if slash < 0 || slash == last {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("expected /outputs/KIND/NAME/OUTPUT"))
+		return
+	}
+	kind, name, output := path[:slash], path[slash+1:last], path[last+1:]
+	generator := r.URL.Query().Get("generator")
+	res := si.index.GetResource(kind, name)
+	if res == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("resource %s of kind %s does not exist", name, kind))
+		return
+	}
+	// find the job of the output, to tell an output that failed to render
+	// from one that does not exist
+	jobs := si.index.renderJobs()
+	job := -1
+	for n := range jobs {
+		j := &jobs[n]
+		if j.resource == res && j.output.Name == output && ((j.generator == nil && generator == "") || (j.generator != nil && j.generator.Generator.Name == generator)) {
+			job = n
+			break
+		}
+	}
+	if job < 0 {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("resource %s of kind %s has no output %s", name, kind, output))
+		return
+	}
+	id := outputID(jobs[job].artifact())
+	artifacts, errs := s.rendered(si)
+	for _, a := range artifacts {
+		if outputID(a) == id {
+			writeJSON(w, http.StatusOK, newArtifactJSON(a))
+			return
+		}
+	}
+	// the output failed to render, with the error prefixed like the job
+	prefix := jobs[job].error(fmt.Errorf("")).Error()
+	for _, err := range errs {
+		if strings.HasPrefix(err.Error(), prefix) {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("output %s was not rendered", id))
+}
+
+// plan serves the artifacts rendered to a repository, sorted by file, along
+// with the errors of every output that failed to render, as the repository
+// of those is not known.
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	si := s.index(w)
+	if si == nil {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/plans/")
+	if _, ok := si.index.repository[name]; !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("repository %s does not exist", name))
+		return
+	}
+	artifacts, errs := s.rendered(si)
+	plan := struct {
+		Repository string          `json:"repository"`
+		Artifacts  []*artifactJSON `json:"artifacts"`
+		Errors     []string        `json:"errors"`
+	}{Repository: name, Artifacts: []*artifactJSON{}, Errors: []string{}}
+	for _, a := range artifacts {
+		if a.Repository == name {
+			plan.Artifacts = append(plan.Artifacts, newArtifactJSON(a))
+		}
+	}
+	sort.SliceStable(plan.Artifacts, func(a, b int) bool { return plan.Artifacts[a].File < plan.Artifacts[b].File })
+	for _, err := range errs {
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// artifactJSON is an Artifact as served, with its content redacted.
+type artifactJSON struct {
+	Kind       string `json:"kind,omitempty"`
+	Resource   string `json:"resource,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Generator  string `json:"generator,omitempty"`
+	Aggregate  string `json:"aggregate,omitempty"`
+	Output     string `json:"output"`
+	Repository string `json:"repository"`
+	File       string `json:"file"`
+	Content    string `json:"content"`
+}
+
+// newArtifactJSON returns the artifact as served.
+func newArtifactJSON(a *Artifact) *artifactJSON {
+	return &artifactJSON{
+		Kind:       a.Kind,
+		Resource:   a.Resource,
+		Namespace:  a.Namespace,
+		Generator:  a.Generator,
+		Aggregate:  a.Aggregate,
+		Output:     a.Output,
+		Repository: a.Repository,
+		File:       a.File,
+		Content:    string(a.Redact(a.Content)),
+	}
+}
+
+// resourceJSON returns the resource as it is exported, with its fields named
+// as in YAML, so that it can be marshalled to JSON.
+func resourceJSON(i *Index, r *v1.Resource) (interface{}, error) {
+	b, err := yaml.Marshal(i.exportedResource(r))
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return jsonValue(v), nil
+}
+
+// writeJSON writes v as the indented JSON body of a response with code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		code = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(b, '\n'))
+}
+
+// writeJSONError writes err as the JSON body of a response with code.
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// serverGet is a helper function that serves a GET request for path, and
+// returns the status code and the JSON body unmarshalled.
+func serverGet(t *testing.T, s *Server, path string) (int, interface{}) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON for %s, got %s", path, w.Body)
+	}
+	return w.Code, body
+}
+
+// Test_Server tests the endpoints of the Server. It expects the kinds, the
+// resources and the rendered outputs of the Index, and errors for those that
+// do not exist.
+func Test_Server(t *testing.T) {
+	s := NewServer()
+	s.SetIndex(mustLoad(t, "testdata/061-template-check"), Diagnostics{})
+	tests := []struct {
+		path     string
+		code     int
+		expected string
+	}{
+		{"/kinds", 200, `[database service]`},
+		{"/resources?kind=service&labels=team=billing", 200, `[]`},
+		{"/resources?labels=team=checkout", 400, `map[error:kind is required]`},
+		{"/resources?kind=service&labels=team", 400, `map[error:invalid label team, expected key=value]`},
+		{"/resources/database/billing", 404, `map[error:resource billing of kind database does not exist]`},
+		{"/outputs/service/orders/config", 200, `map[content:# orders (checkout) orders.db
+port=8080 file:orders.txt kind:service output:config repository:repo resource:orders]`},
+		{"/outputs/service/orders/other", 404, `map[error:resource orders of kind service has no output other]`},
+		{"/plans/repo", 200, `map[artifacts:[map[content:# billing (<no value>) orders.db
+port=<no value> file:billing.txt kind:service output:config repository:repo resource:billing] map[content:# orders (checkout) orders.db
+port=8080 file:orders.txt kind:service output:config repository:repo resource:orders]] errors:[] repository:repo]`},
+		{"/plans/other", 404, `map[error:repository other does not exist]`},
+	}
+	for _, test := range tests {
+		code, body := serverGet(t, s, test.path)
+		if code != test.code || fmt.Sprint(body) != test.expected {
+			t.Errorf("Expected %d %s for %s, got %d %v", test.code, test.expected, test.path, code, body)
+		}
+	}
+
+	resources := map[string]string{
+		"/resources?kind=service":                      "[billing orders]",
+		"/resources?kind=service&labels=team=checkout": "[orders]",
+		"/resources/database/orders":                   "[orders]",
+	}
+	for path, expected := range resources {
+		code, body := serverGet(t, s, path)
+		if m, ok := body.(map[string]interface{}); ok {
+			body = []interface{}{m}
+		}
+		names := []interface{}{}
+		for _, r := range body.([]interface{}) {
+			names = append(names, r.(map[string]interface{})["name"])
+		}
+		if code != 200 || fmt.Sprint(names) != expected {
+			t.Errorf("Expected %s for %s, got %d %v", expected, path, code, body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/kinds", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+// Test_Server_Secrets tests the outputs endpoint of the Server. It expects
+// the secrets of the content of artifacts to be redacted.
+func Test_Server_Secrets(t *testing.T) {
+	r := secretRenderer(t)
+	s := NewServer()
+	s.Renderer = func(i *Index) *Renderer { return r }
+	s.SetIndex(r.index, Diagnostics{})
+	code, body := serverGet(t, s, "/outputs/service/orders/config")
+	expected := "orders:[REDACTED]@orders.db [REDACTED] [REDACTED]"
+	if content := body.(map[string]interface{})["content"]; code != 200 || content != expected {
+		t.Errorf("Expected %s, got %d %v", expected, code, body)
+	}
+}
+
+// Test_Server_SetIndex tests the SetIndex function of the Server. It expects
+// no Index to be served before one without errors is set, and an Index with
+// errors not to replace the one served.
+func Test_Server_SetIndex(t *testing.T) {
+	s := NewServer()
+	if code, body := serverGet(t, s, "/kinds"); code != 503 || fmt.Sprint(body) != "map[error:no model is loaded]" {
+		t.Errorf("Expected an error, got %d %v", code, body)
+	}
+	if code, body := serverGet(t, s, "/health"); code != 503 || body.(map[string]interface{})["status"] != "unavailable" {
+		t.Errorf("Expected unavailable, got %d %v", code, body)
+	}
+
+	s.SetIndex(mustLoad(t, "testdata/061-template-check"), Diagnostics{})
+	if code, body := serverGet(t, s, "/health"); code != 200 || body.(map[string]interface{})["status"] != "ok" {
+		t.Errorf("Expected ok, got %d %v", code, body)
+	}
+
+	diags := Diagnostics{{Severity: v1.ErrorSeverity, Err: fmt.Errorf("invalid model")}}
+	s.SetIndex(NewIndex(), diags)
+	code, body := serverGet(t, s, "/health")
+	if code != 200 || body.(map[string]interface{})["status"] != "stale" || fmt.Sprint(body.(map[string]interface{})["diagnostics"]) != "[map[message:invalid model severity:error]]" {
+		t.Errorf("Expected stale, got %d %v", code, body)
+	}
+	if code, body := serverGet(t, s, "/kinds"); code != 200 || fmt.Sprint(body) != "[database service]" {
+		t.Errorf("Expected the previous Index to be served, got %d %v", code, body)
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// source is a tree of files that documents are loaded from.
+type source struct {
+	// walk walks the tree, calling fn for each file and directory.
+	walk func(fn fs.WalkDirFunc) error
+	// readFile returns the content of the file at name.
+	readFile func(name string) ([]byte, error)
+	// resolve returns the path of file, given relative to the directory of
+	// the document at doc. It returns an error if the path is outside of the
+	// tree.
+	resolve func(doc string, file string) (string, error)
+}
+
+// dirSource returns a source for the directory dir on the local filesystem.
+func dirSource(dir string) *source {
+	return &source{
+		walk: func(fn fs.WalkDirFunc) error {
+			return filepath.WalkDir(dir, fn)
+		},
+		readFile: ioutil.ReadFile,
+		resolve: func(doc string, file string) (string, error) {
+			if filepath.IsAbs(file) {
+				return "", fmt.Errorf("file %s must be a relative path", file)
+			}
+			p := filepath.Join(filepath.Dir(doc), filepath.FromSlash(file))
+			if !withinDir(dir, p) {
+				return "", fmt.Errorf("file %s is outside of %s", file, dir)
+			}
+			// a symlink in the tree may point outside of it, so the check is
+			// repeated with the links followed, unless the file does not exist,
+			// which reading it reports
+			real, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return p, nil
+			}
+			realDir, err := filepath.EvalSymlinks(dir)
+			if err != nil || !withinDir(realDir, real) {
+				return "", fmt.Errorf("file %s is outside of %s", file, dir)
+			}
+			return p, nil
+		},
+	}
+}
+
+// withinDir returns true if the path p is dir or is under it.
+func withinDir(dir string, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fsSource returns a source for the filesystem fsys.
+func fsSource(fsys fs.FS) *source {
+	return &source{
+		walk: func(fn fs.WalkDirFunc) error {
+			return fs.WalkDir(fsys, ".", fn)
+		},
+		readFile: func(name string) ([]byte, error) {
+			return fs.ReadFile(fsys, name)
+		},
+		resolve: func(doc string, file string) (string, error) {
+			if path.IsAbs(file) {
+				return "", fmt.Errorf("file %s must be a relative path", file)
+			}
+			p := path.Join(path.Dir(doc), file)
+			if !fs.ValidPath(p) {
+				return "", fmt.Errorf("file %s is outside of the filesystem", file)
+			}
+			return p, nil
+		},
+	}
+}
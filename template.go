@@ -0,0 +1,302 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// TemplateLookup returns the named template spec, or nil if there is none.
+type TemplateLookup func(name string) *v1.TemplateSpec
+
+// parsedSpec is a template spec parsed on its own, without its layouts or
+// partials.
+type parsedSpec struct {
+	spec *v1.TemplateSpec
+	// name is the name of the template qualified by its namespace, which is
+	// the name of the tree of its content.
+	name string
+	// aliases are the names other than name the template is referred to by
+	// in the templates it is resolved with, such as `header` for
+	// `team-a/header`.
+	aliases []string
+	// trees is the parse tree of the content and of each template it
+	// defines, keyed by name.
+	trees map[string]*parse.Tree
+	// refs is the list of template names referenced by `template`, `block`
+	// and `include`.
+	refs []string
+}
+
+// parseSpec parses the content of a template spec on its own.
+func parseSpec(spec *v1.TemplateSpec) (*parsedSpec, error) {
+	name := qualifiedName(spec.Namespace, spec.Name)
+	t, err := template.New(name).Funcs(parseFuncs).Parse(spec.Content)
+	if err != nil {
+		return nil, err
+	}
+	p := &parsedSpec{spec: spec, name: name, trees: map[string]*parse.Tree{}}
+	seen := map[string]bool{}
+	for _, d := range t.Templates() {
+		if d.Tree == nil {
+			continue
+		}
+		p.trees[d.Name()] = d.Tree
+		walkTemplate(d.Tree.Root, func(n parse.Node) {
+			name := ""
+			switch n := n.(type) {
+			case *parse.TemplateNode:
+				name = n.Name
+			case *parse.CommandNode:
+				name = includedName(n)
+			}
+			if name != "" && !seen[name] {
+				seen[name] = true
+				p.refs = append(p.refs, name)
+			}
+		})
+	}
+	return p, nil
+}
+
+// includedName returns the name of the template included by an `include`
+// command with a literal name, or "" if the command is not one.
+func includedName(cmd *parse.CommandNode) string {
+	if len(cmd.Args) < 2 {
+		return ""
+	}
+	if id, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || id.Ident != "include" {
+		return ""
+	}
+	if s, ok := cmd.Args[1].(*parse.StringNode); ok {
+		return s.Text
+	}
+	return ""
+}
+
+// walkTemplate calls fn for node and every node below it.
+func walkTemplate(node parse.Node, fn func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkTemplate(c, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkTemplate(c, fn)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			walkTemplate(a, fn)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkTemplate(n.Pipe, fn)
+	}
+}
+
+// walkBranch walks the pipeline and both lists of an if, range or with node.
+func walkBranch(b *parse.BranchNode, fn func(parse.Node)) {
+	walkTemplate(b.Pipe, fn)
+	walkTemplate(b.List, fn)
+	walkTemplate(b.ElseList, fn)
+}
+
+// parseFuncs are the functions templates are parsed with. They are replaced
+// by the functions of the engine when the template is compiled.
+var parseFuncs = template.FuncMap{
+	"include": func(name string, data interface{}) (string, error) {
+		return "", nil
+	},
+}
+
+// templateRef returns the name of the template a template of the namespace
+// refers to as name, which is the template of that name in the namespace if
+// there is one, and the template of the default namespace otherwise.
+func templateRef(namespace string, name string, lookup TemplateLookup) string {
+	if namespace != "" && !strings.Contains(name, "/") {
+		if lookup(qualifiedName(namespace, name)) != nil {
+			return qualifiedName(namespace, name)
+		}
+	}
+	return name
+}
+
+// resolveTemplate parses the named template together with its layouts and
+// the partials it includes. A template with a parent is executed as its
+// parent with the blocks it defines filled in; the parent may have a parent
+// of its own. Any template referred to by `template` or `include` that is not
+// defined in the layout chain is looked up as a partial. Parents and
+// partials are looked up in the namespace of the template referring to them
+// first, as the templates of outputs are. The parsed specs are returned
+// outermost layout first, followed by the partials, together with the name
+// of the template to execute.
+func resolveTemplate(name string, lookup TemplateLookup) ([]*parsedSpec, string, error) {
+	// resolve the layout chain, from the template to its outermost parent
+	chain := []*parsedSpec{}
+	inChain := map[string]bool{}
+	path := []string{}
+	for n := name; ; n = templateRef(chain[len(chain)-1].spec.Namespace, chain[len(chain)-1].spec.Parent, lookup) {
+		path = append(path, n)
+		if inChain[n] {
+			return nil, "", fmt.Errorf("template %s: layout cycle %s", name, strings.Join(path, " -> "))
+		}
+		spec := lookup(n)
+		if spec == nil {
+			if n == name {
+				return nil, "", fmt.Errorf("template %s does not exist", name)
+			}
+			return nil, "", fmt.Errorf("template %s: parent %s does not exist", chain[len(chain)-1].name, n)
+		}
+		p, err := parseSpec(spec)
+		if err != nil {
+			return nil, "", err
+		}
+		inChain[n] = true
+		chain = append(chain, p)
+		if spec.Parent == "" {
+			break
+		}
+	}
+
+	// add the layouts outermost first so that inner templates override the
+	// blocks of their parents, then resolve the partials
+	specs := []*parsedSpec{}
+	for n := len(chain) - 1; n >= 0; n-- {
+		specs = append(specs, chain[n])
+	}
+	defined := map[string]bool{}
+	byName := map[string]*parsedSpec{}
+	for _, p := range specs {
+		for tn := range p.trees {
+			defined[tn] = true
+		}
+		byName[p.name] = p
+	}
+	for n := 0; n < len(specs); n++ {
+		for _, ref := range specs[n].refs {
+			if defined[ref] {
+				continue
+			}
+			qualified := templateRef(specs[n].spec.Namespace, ref, lookup)
+			// a partial of the namespace is also added to the set under the
+			// name it is referred to by
+			if p, ok := byName[qualified]; ok {
+				p.aliases = append(p.aliases, ref)
+				defined[ref] = true
+				continue
+			}
+			spec := lookup(qualified)
+			if spec == nil {
+				return nil, "", fmt.Errorf("template %s: partial %s does not exist", specs[n].name, ref)
+			}
+			p, err := parseSpec(spec)
+			if err != nil {
+				return nil, "", err
+			}
+			for tn := range p.trees {
+				defined[tn] = true
+			}
+			if qualified != ref {
+				p.aliases = append(p.aliases, ref)
+				defined[ref] = true
+			}
+			byName[p.name] = p
+			specs = append(specs, p)
+		}
+	}
+	if err := checkIncludeCycles(name, specs); err != nil {
+		return nil, "", err
+	}
+	return specs, chain[len(chain)-1].name, nil
+}
+
+// addParseTrees calls add for every tree of the parsed specs, in the order
+// they must be added to a template set.
+func addParseTrees(specs []*parsedSpec, add func(name string, tree *parse.Tree) error) error {
+	for _, p := range specs {
+		// add the content tree last so that it is not replaced by a define
+		// of the same name
+		for tn, tree := range p.trees {
+			if tn == p.name {
+				continue
+			}
+			if err := add(tn, tree); err != nil {
+				return err
+			}
+		}
+		for _, alias := range p.aliases {
+			if err := add(alias, p.trees[p.name]); err != nil {
+				return err
+			}
+		}
+		if err := add(p.name, p.trees[p.name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIncludeCycles returns an error if the specs of the named template
+// refer to each other in a cycle.
+func checkIncludeCycles(name string, specs []*parsedSpec) error {
+	byName := map[string]*parsedSpec{}
+	for _, p := range specs {
+		byName[p.name] = p
+		for _, alias := range p.aliases {
+			byName[alias] = p
+		}
+	}
+	// visiting is true while a spec is on the current path, and false once
+	// all the specs it refers to have been checked
+	visiting := map[string]bool{}
+	path := []string{}
+	var visit func(p *parsedSpec) error
+	visit = func(p *parsedSpec) error {
+		path = append(path, p.name)
+		if v, ok := visiting[p.name]; ok {
+			if v {
+				return fmt.Errorf("template %s: include cycle %s", name, strings.Join(path, " -> "))
+			}
+			path = path[:len(path)-1]
+			return nil
+		}
+		visiting[p.name] = true
+		for _, ref := range p.refs {
+			if r, ok := byName[ref]; ok {
+				if err := visit(r); err != nil {
+					return err
+				}
+			}
+		}
+		visiting[p.name] = false
+		path = path[:len(path)-1]
+		return nil
+	}
+	for _, p := range specs {
+		if err := visit(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
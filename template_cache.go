@@ -0,0 +1,137 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// templateCache caches compiled templates keyed by name. An entry is valid as
+// long as the content hash of every template it was compiled from is
+// unchanged. It is safe for concurrent use.
+type templateCache struct {
+	mu      sync.Mutex
+	entries map[string]*compiledTemplate
+}
+
+// newTemplateCache returns a new, empty templateCache
+func newTemplateCache() *templateCache {
+	return &templateCache{entries: map[string]*compiledTemplate{}}
+}
+
+// get returns the named template compiled, compiling it if it is not cached
+// or any template it was compiled from changed since it was cached.
+func (c *templateCache) get(name string, lookup TemplateLookup) (Executable, error) {
+	return c.compiled(name, lookup, false)
+}
+
+// getStrict returns the named template compiled to fail on missing keys, as
+// get does.
+func (c *templateCache) getStrict(name string, lookup TemplateLookup) (Executable, error) {
+	return c.compiled(name, lookup, true)
+}
+
+// compiled returns the named template compiled, strict or not, using the
+// cache. Strict templates are cached under their name followed by a NUL
+// byte.
+func (c *templateCache) compiled(name string, lookup TemplateLookup, strict bool) (Executable, error) {
+	key := name
+	if strict {
+		key += "\x00"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && e.current(lookup) {
+		return e.template, nil
+	}
+	spec := lookup(name)
+	if spec == nil {
+		return nil, fmt.Errorf("template %s does not exist", name)
+	}
+	engine, err := lookupEngine(spec.Engine)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %s", name, err)
+	}
+	compile := engine.Compile
+	if s, ok := engine.(StrictEngine); ok && strict {
+		compile = s.CompileStrict
+	}
+	// record every template the engine looks up as a dependency
+	e := &compiledTemplate{deps: map[string]string{}}
+	e.template, err = compile(name, func(name string) *v1.TemplateSpec {
+		spec := lookup(name)
+		if spec != nil {
+			e.deps[name] = templateHash(spec)
+		}
+		return spec
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = e
+	return e.template, nil
+}
+
+// compiledTemplate is a template compiled by its engine.
+type compiledTemplate struct {
+	template Executable
+	// deps is the hash of every template spec the compiled template was
+	// built from, keyed by name.
+	deps map[string]string
+}
+
+// deps returns the names of the templates the named template was compiled
+// from, including itself, in sorted order. The template must have been
+// compiled.
+func (c *templateCache) deps(name string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deps := []string{}
+	if e, ok := c.entries[name]; ok {
+		for d := range e.deps {
+			deps = append(deps, d)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// invalidate removes every template compiled from the named template from
+// the cache.
+func (c *templateCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n, e := range c.entries {
+		if _, ok := e.deps[name]; ok {
+			delete(c.entries, n)
+		}
+	}
+}
+
+// current returns true if none of the templates the compiled template was
+// built from changed.
+func (c *compiledTemplate) current(lookup TemplateLookup) bool {
+	for name, hash := range c.deps {
+		spec := lookup(name)
+		if spec == nil || templateHash(spec) != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// templateHash returns the content hash of a template spec, covering the
+// fields that affect how it is compiled.
+func templateHash(spec *v1.TemplateSpec) string {
+	return contentHash(spec.Engine + "\x00" + spec.Parent + "\x00" + spec.Content)
+}
+
+// contentHash returns the hex encoded SHA-256 hash of content.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
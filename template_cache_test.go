@@ -0,0 +1,87 @@
+package core
+
+import (
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Index_ParsedTemplate_Cached tests the parsedTemplate function of the
+// Index. It expects the same parsed template to be returned until the
+// template is removed and added again.
+func Test_Index_ParsedTemplate_Cached(t *testing.T) {
+	i := NewIndex()
+	tmpl := &v1.Template{
+		APIVersion: "v1",
+		Template: v1.TemplateSpec{
+			Name:    "template-1",
+			Content: "test",
+		},
+	}
+	if err := i.AddTemplate(tmpl); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	first, err := i.parsedTemplate("template-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	second, err := i.parsedTemplate("template-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if first != second {
+		t.Errorf("Expected the cached template to be returned")
+	}
+	if err := i.RemoveTemplate(tmpl); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if len(i.templates.entries) != 0 {
+		t.Errorf("Expected 0 cached templates, got %d", len(i.templates.entries))
+	}
+	if _, err := i.parsedTemplate("template-1"); err == nil || err.Error() != "template template-1 does not exist" {
+		t.Errorf("Expected template template-1 does not exist, got %v", err)
+	}
+	if err := i.AddTemplate(tmpl); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	third, err := i.parsedTemplate("template-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if third == first {
+		t.Errorf("Expected the template to be parsed again")
+	}
+}
+
+// Test_Index_ParsedTemplate_ContentChanged tests the parsedTemplate function
+// of the Index. It expects a template to be parsed again when its content
+// changes.
+func Test_Index_ParsedTemplate_ContentChanged(t *testing.T) {
+	i := NewIndex()
+	tmpl := &v1.Template{
+		APIVersion: "v1",
+		Template: v1.TemplateSpec{
+			Name:    "template-1",
+			Content: "test",
+		},
+	}
+	if err := i.AddTemplate(tmpl); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	first, err := i.parsedTemplate("template-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	tmpl.Template.Content = "{{ .Self.name }}"
+	second, err := i.parsedTemplate("template-1")
+	if err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if first == second {
+		t.Errorf("Expected the template to be parsed again")
+	}
+	tmpl.Template.Content = "{{ .Self.name"
+	if _, err := i.parsedTemplate("template-1"); err == nil {
+		t.Errorf("Expected a parse error, got nil")
+	}
+}
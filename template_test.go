@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/tpology/core/api/v1"
+)
+
+// Test_Renderer_Render_Layout tests rendering a template with a parent layout
+// and partials. It expects the blocks of the layout to be filled in by the
+// template, and the partials to receive the data they are passed.
+func Test_Renderer_Render_Layout(t *testing.T) {
+	artifacts, errs := NewRenderer(mustLoad(t, "testdata/030-template-layout")).Render(context.Background())
+	if len(errs) != 0 {
+		t.Fatalf("Expected 0 errors, got %v", errs)
+	}
+	if len(artifacts) != 2 {
+		t.Fatalf("Expected 2 artifacts, got %d", len(artifacts))
+	}
+	if string(artifacts[0].Content) != "# Copyright team-a\n# resource-1\nport: 8080\n" {
+		t.Errorf("Expected page content, got %q", artifacts[0].Content)
+	}
+	if string(artifacts[1].Content) != "# Copyright team-a\n# untitled\n\n" {
+		t.Errorf("Expected layout content, got %q", artifacts[1].Content)
+	}
+}
+
+// Test_Index_ParsedTemplate_PartialChanged tests that a compiled template is
+// invalidated when a partial it includes is removed from the Index.
+func Test_Index_ParsedTemplate_PartialChanged(t *testing.T) {
+	i := mustLoad(t, "testdata/030-template-layout")
+	if _, err := i.parsedTemplate("page"); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if err := i.RemoveTemplate(i.template["port"]); err != nil {
+		t.Fatalf("Expected nil, got %s", err)
+	}
+	if _, ok := i.templates.entries["page"]; ok {
+		t.Errorf("Expected page to be invalidated")
+	}
+	_, err := i.parsedTemplate("page")
+	if err == nil || err.Error() != "template page: partial port does not exist" {
+		t.Errorf("Expected template page: partial port does not exist, got %v", err)
+	}
+}
+
+// Test_Index_ParsedTemplate_Namespaces tests that the layouts and partials of
+// a template are looked up in its namespace before the default namespace. It
+// expects templates of the same name in different namespaces not to collide.
+func Test_Index_ParsedTemplate_Namespaces(t *testing.T) {
+	templates := []v1.TemplateSpec{
+		{Name: "layout", Content: `default {{ block "body" . }}{{ end }}`},
+		{Name: "footer", Content: `footer`},
+		{Name: "layout", Namespace: "team-a", Content: `team-a {{ block "body" . }}{{ end }} {{ include "footer" . }}`},
+		{Name: "footer", Namespace: "team-a", Content: `a-footer`},
+		{Name: "page", Namespace: "team-a", Parent: "layout", Content: `{{ define "body" }}page{{ end }}`},
+		{Name: "footer", Namespace: "team-b", Content: `b-footer {{ include "team-a/footer" . }}`},
+		{Name: "page", Namespace: "team-b", Parent: "layout", Content: `{{ define "body" }}{{ template "footer" . }}{{ end }}`},
+	}
+	tests := map[string]string{
+		"team-a/page": "team-a page a-footer",
+		"team-b/page": "default b-footer a-footer",
+	}
+	for name, expected := range tests {
+		out, err := execute(t, name, nil, templates...)
+		if err != nil {
+			t.Errorf("Expected nil for %s, got %s", name, err)
+			continue
+		}
+		if out != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, name, out)
+		}
+	}
+}
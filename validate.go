@@ -2,6 +2,9 @@ package core
 
 import (
 	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
 
 	v1 "github.com/tpology/core/api/v1"
 )
@@ -11,18 +14,70 @@ func (i *Index) validate() []error {
 	errs := []error{}
 	// validate resources
 	for _, resources := range i.resourceByKind {
-		for _, r := range resources {
+		for name, r := range resources {
 			errs = append(errs, validateResource(r)...)
+			errs = append(errs, i.validateReferences(r)...)
+			errs = append(errs, validateSecrets(r)...)
+			for _, d := range r.Resource.DependsOn {
+				if i.ResolveResource(r.Resource.Namespace, d) == nil {
+					errs = append(errs, fmt.Errorf("resource %s of kind %s: dependsOn resource %s of kind %s does not exist", name, r.Resource.Kind, qualifiedName(d.Namespace, d.Name), d.Kind))
+				}
+			}
+			for o := range r.Resource.Outputs {
+				for _, err := range validateOutput(&r.Resource.Outputs[o]) {
+					errs = append(errs, fmt.Errorf("resource %s of kind %s: output %s: %s", name, r.Resource.Kind, r.Resource.Outputs[o].Name, err))
+				}
+			}
 		}
 	}
 	// validate templates
 	for _, t := range i.template {
 		errs = append(errs, validateTemplate(t)...)
 	}
+	// compile templates so that syntax errors, missing partials and cycles
+	// are reported at load time
+	seen := map[string]bool{}
+	for _, name := range sortedTemplateNames(i.template) {
+		if name == "" {
+			continue
+		}
+		if _, err := i.parsedTemplate(name); err != nil && !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
 	// validate repositories
 	for _, r := range i.repository {
 		errs = append(errs, validateRepository(r)...)
 	}
+	// validate aggregates
+	for _, a := range i.aggregate {
+		errs = append(errs, validateAggregate(a)...)
+		for o := range a.Aggregate.Outputs {
+			for _, err := range validateOutput(&a.Aggregate.Outputs[o]) {
+				errs = append(errs, fmt.Errorf("aggregate %s: output %s: %s", a.Aggregate.Name, a.Aggregate.Outputs[o].Name, err))
+			}
+		}
+	}
+	// validate generators
+	for _, g := range i.generator {
+		errs = append(errs, validateGenerator(g)...)
+		for o := range g.Generator.Outputs {
+			for _, err := range validateOutput(&g.Generator.Outputs[o]) {
+				errs = append(errs, fmt.Errorf("generator %s: output %s: %s", g.Generator.Name, g.Generator.Outputs[o].Name, err))
+			}
+			// the file of a generator output is rendered for every resource
+			// it selects, which would all write to it if it were not a
+			// template
+			if o := &g.Generator.Outputs[o]; o.File != "" && !strings.Contains(o.File, "{{") {
+				errs = append(errs, fmt.Errorf("generator %s: output %s: file %s must be a template, as it is rendered for every resource the generator selects", g.Generator.Name, o.Name, o.File))
+			}
+		}
+	}
+	// validate policies
+	for _, p := range i.policy {
+		errs = append(errs, validatePolicy(p)...)
+	}
 	return errs
 }
 
@@ -37,6 +92,20 @@ func validateResource(r *v1.Resource) []error {
 	if r.Resource.Name == "" {
 		errs = append(errs, fmt.Errorf("resource name is required"))
 	}
+	errs = append(errs, validateNamespace("resource", r.Resource.Namespace, r.Resource.Name)...)
+	return errs
+}
+
+// validateNamespace validates the namespace and name of a document, which
+// must not contain a slash as it separates them in qualified names.
+func validateNamespace(kind string, namespace string, name string) []error {
+	errs := []error{}
+	if strings.Contains(namespace, "/") {
+		errs = append(errs, fmt.Errorf("%s %s namespace %s must not contain /", kind, name, namespace))
+	}
+	if strings.Contains(name, "/") {
+		errs = append(errs, fmt.Errorf("%s name %s must not contain /", kind, name))
+	}
 	return errs
 }
 
@@ -47,6 +116,7 @@ func validateTemplate(t *v1.Template) []error {
 	if t.Template.Name == "" {
 		errs = append(errs, fmt.Errorf("template name is required"))
 	}
+	errs = append(errs, validateNamespace("template", t.Template.Namespace, t.Template.Name)...)
 	return errs
 }
 
@@ -65,6 +135,110 @@ func validateRepository(r *v1.Repository) []error {
 	if r.Repository.Branch == "" {
 		errs = append(errs, fmt.Errorf("repository branch is required"))
 	}
+	errs = append(errs, validateNamespace("repository", r.Repository.Namespace, r.Repository.Name)...)
+	return errs
+}
+
+// validateOutput validates the output. The file and repository may be
+// templates, in which case they must parse, and the file is validated again
+// once it is rendered.
+func validateOutput(o *v1.OutputSpec) []error {
+	errs := []error{}
+	if _, err := parseString("repository", o.Repository); err != nil {
+		errs = append(errs, err)
+	}
+	t, err := parseString("file", o.File)
+	if err != nil {
+		errs = append(errs, err)
+	} else if t == nil {
+		if err := validateOutputFile(o.File); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateOutputFile validates the path of an output artifact. It must be a
+// clean, relative path that does not escape the root of the repository.
+func validateOutputFile(file string) error {
+	if file == "" {
+		return fmt.Errorf("file is required")
+	}
+	if path.IsAbs(file) || filepath.IsAbs(file) {
+		return fmt.Errorf("file %s must be a relative path", file)
+	}
+	if clean := path.Clean(file); clean != file {
+		return fmt.Errorf("file %s must be a clean path (%s)", file, clean)
+	}
+	if file == "." || file == ".." || strings.HasPrefix(file, "../") {
+		return fmt.Errorf("file %s is outside of the repository", file)
+	}
+	return nil
+}
+
+// validateGenerator validates the generator
+func validateGenerator(g *v1.Generator) []error {
+	errs := []error{}
+	// validate name
+	if g.Generator.Name == "" {
+		errs = append(errs, fmt.Errorf("generator name is required"))
+	}
+	// validate selector
+	if g.Generator.Selector.Kind == "" {
+		errs = append(errs, fmt.Errorf("generator %s selector kind is required", g.Generator.Name))
+	}
+	return errs
+}
+
+// validateAggregate validates the aggregate
+func validateAggregate(a *v1.Aggregate) []error {
+	errs := []error{}
+	// validate name
+	if a.Aggregate.Name == "" {
+		errs = append(errs, fmt.Errorf("aggregate name is required"))
+	}
+	// validate selectors
+	if len(a.Aggregate.Selectors) == 0 {
+		errs = append(errs, fmt.Errorf("aggregate %s selectors are required", a.Aggregate.Name))
+	}
+	for _, s := range a.Aggregate.Selectors {
+		if s.Kind == "" {
+			errs = append(errs, fmt.Errorf("aggregate %s selector kind is required", a.Aggregate.Name))
+		}
+	}
+	return errs
+}
+
+// validatePolicy validates the policy and its rules
+func validatePolicy(p *v1.Policy) []error {
+	errs := []error{}
+	// validate name
+	if p.Policy.Name == "" {
+		errs = append(errs, fmt.Errorf("policy name is required"))
+	}
+	// validate rules
+	for n := range p.Policy.Rules {
+		rule := &p.Policy.Rules[n]
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("policy %s rule name is required", p.Policy.Name))
+			continue
+		}
+		switch rule.Target {
+		case v1.ResourceTarget, v1.RepositoryTarget, v1.OutputTarget:
+		default:
+			errs = append(errs, fmt.Errorf("policy %s: rule %s: unknown target %s", p.Policy.Name, rule.Name, rule.Target))
+		}
+		switch rule.Severity {
+		case "", v1.ErrorSeverity, v1.WarningSeverity, v1.InfoSeverity:
+		default:
+			errs = append(errs, fmt.Errorf("policy %s: rule %s: unknown severity %s", p.Policy.Name, rule.Name, rule.Severity))
+		}
+		if rule.Condition == "" {
+			errs = append(errs, fmt.Errorf("policy %s: rule %s: condition is required", p.Policy.Name, rule.Name))
+		} else if _, err := parseRule(p, rule); err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: rule %s: %s", p.Policy.Name, rule.Name, err))
+		}
+	}
 	return errs
 }
 
@@ -130,3 +304,91 @@ func validateRepositoryFields(r map[string]interface{}) []error {
 func validateRepositorySpecFields(r map[interface{}]interface{}) []error {
 	return validateSpecFields("repository", r, v1.ValidRepositorySpecFields)
 }
+
+// validateGeneratorFields validates the fields in a Generator.
+func validateGeneratorFields(r map[string]interface{}) []error {
+	return validateFields("generator", r, v1.ValidGeneratorFields)
+}
+
+// validateGeneratorSpecFields validates the fields in a GeneratorSpec, and
+// in its selector and outputs.
+func validateGeneratorSpecFields(r map[interface{}]interface{}) []error {
+	if errs := validateSpecFields("generator", r, v1.ValidGeneratorSpecFields); len(errs) > 0 {
+		return errs
+	}
+	if errs := validateSelectorSpecFields(r["selector"]); len(errs) > 0 {
+		return errs
+	}
+	return validateOutputSpecListFields(r["outputs"])
+}
+
+// validateAggregateFields validates the fields in an Aggregate.
+func validateAggregateFields(r map[string]interface{}) []error {
+	return validateFields("aggregate", r, v1.ValidAggregateFields)
+}
+
+// validateAggregateSpecFields validates the fields in an AggregateSpec, and
+// in its selectors and outputs.
+func validateAggregateSpecFields(r map[interface{}]interface{}) []error {
+	if errs := validateSpecFields("aggregate", r, v1.ValidAggregateSpecFields); len(errs) > 0 {
+		return errs
+	}
+	if selectors, ok := r["selectors"].([]interface{}); ok {
+		for _, s := range selectors {
+			if errs := validateSelectorSpecFields(s); len(errs) > 0 {
+				return errs
+			}
+		}
+	}
+	return validateOutputSpecListFields(r["outputs"])
+}
+
+// validatePolicyFields validates the fields in a Policy.
+func validatePolicyFields(r map[string]interface{}) []error {
+	return validateFields("policy", r, v1.ValidPolicyFields)
+}
+
+// validatePolicySpecFields validates the fields in a PolicySpec, and in its
+// rules and their selectors.
+func validatePolicySpecFields(r map[interface{}]interface{}) []error {
+	if errs := validateSpecFields("policy", r, v1.ValidPolicySpecFields); len(errs) > 0 {
+		return errs
+	}
+	if rules, ok := r["rules"].([]interface{}); ok {
+		for _, rule := range rules {
+			if rule, ok := rule.(map[interface{}]interface{}); ok {
+				if errs := validateSpecFields("rule", rule, v1.ValidRuleSpecFields); len(errs) > 0 {
+					return errs
+				}
+				if errs := validateSelectorSpecFields(rule["selector"]); len(errs) > 0 {
+					return errs
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateSelectorSpecFields validates the fields in a SelectorSpec, if r is
+// one.
+func validateSelectorSpecFields(r interface{}) []error {
+	if selector, ok := r.(map[interface{}]interface{}); ok {
+		return validateSpecFields("selector", selector, v1.ValidSelectorSpecFields)
+	}
+	return nil
+}
+
+// validateOutputSpecListFields validates the fields in each OutputSpec of a
+// list, if r is one.
+func validateOutputSpecListFields(r interface{}) []error {
+	if outputs, ok := r.([]interface{}); ok {
+		for _, o := range outputs {
+			if output, ok := o.(map[interface{}]interface{}); ok {
+				if errs := validateOutputSpecFields(output); len(errs) > 0 {
+					return errs
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package core
 
-import "testing"
+import (
+	"sort"
+	"testing"
+)
 
 // Test_Validate_MissingResourceKind tests that a resource without a kind is not valid
 func Test_Validate_MissingResourceKind(t *testing.T) {
@@ -62,6 +65,18 @@ func Test_Validate_InvalidResourceSpecField(t *testing.T) {
 	}
 }
 
+// Test_Valid_InvalidOutputSpecField tests that an invalid output spec field of a resource is not valid
+func Test_Valid_InvalidOutputSpecField(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/068-invalid-output-spec-field")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if errs[0].Error() != "testdata/validate/068-invalid-output-spec-field/resource-1.yaml: invalid output spec field `repo`" {
+		t.Errorf("expected 'testdata/validate/068-invalid-output-spec-field/resource-1.yaml: invalid output spec field `repo`', got '%s'", errs[0].Error())
+	}
+}
+
 // Test_Valid_InvalidTemplateField tests that an invalid template field is not valid
 func Test_Validate_InvalidTemplateField(t *testing.T) {
 	i := NewIndex()
@@ -145,3 +160,252 @@ func Test_Validate_MissingRepositoryBranch(t *testing.T) {
 		t.Errorf("expected 'repository branch is required', got '%s'", errs[0].Error())
 	}
 }
+
+// Test_Validate_InvalidTemplateSyntax tests that a template that does not parse is not valid
+func Test_Validate_InvalidTemplateSyntax(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/029-invalid-template-syntax")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "template: template-1:2: function \"nofunc\" not defined" {
+		t.Errorf("expected 'template: template-1:2: function \"nofunc\" not defined', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_MissingPartial tests that a template including a template that does not exist is not valid
+func Test_Validate_MissingPartial(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/031-missing-partial")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "template template-1: partial missing does not exist" {
+		t.Errorf("expected 'template template-1: partial missing does not exist', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_IncludeCycle tests that templates including each other are not valid
+func Test_Validate_IncludeCycle(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/032-include-cycle")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d", len(errs))
+	}
+	if errs[0].Error() != "template template-1: include cycle template-1 -> template-2 -> template-1" {
+		t.Errorf("expected 'template template-1: include cycle template-1 -> template-2 -> template-1', got '%s'", errs[0].Error())
+	}
+	if errs[1].Error() != "template template-2: include cycle template-2 -> template-1 -> template-2" {
+		t.Errorf("expected 'template template-2: include cycle template-2 -> template-1 -> template-2', got '%s'", errs[1].Error())
+	}
+}
+
+// Test_Validate_MissingParent tests that a template with a parent that does not exist is not valid
+func Test_Validate_MissingParent(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/033-missing-parent")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "template template-1: parent missing does not exist" {
+		t.Errorf("expected 'template template-1: parent missing does not exist', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_UnknownEngine tests that a template with an engine that does not exist is not valid
+func Test_Validate_UnknownEngine(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/036-unknown-engine")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "template template-1: engine missing does not exist" {
+		t.Errorf("expected 'template template-1: engine missing does not exist', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_MissingGeneratorSelectorKind tests that a generator without a selector kind is not valid
+func Test_Validate_MissingGeneratorSelectorKind(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/038-missing-generator-selector-kind")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "generator generator-1 selector kind is required" {
+		t.Errorf("expected 'generator generator-1 selector kind is required', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_GeneratorFixedFile tests that generator outputs whose file is not a template are not valid
+func Test_Validate_GeneratorFixedFile(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/065-generator-fixed-file")
+	expected := "generator generator-1: output deploy: file deploy.yaml must be a template, as it is rendered for every resource the generator selects"
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected '%s', got %v", expected, errs)
+	}
+}
+
+// Test_Validate_ReferenceCycle tests that resources whose data refer to each other are not valid
+func Test_Validate_ReferenceCycle(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/067-reference-cycle")
+	expected := []string{
+		"resource billing of kind service: reference cycle through resource billing of kind service",
+		"resource orders of kind service: reference cycle through resource orders of kind service",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	// resources are validated in no particular order
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	sort.Strings(messages)
+	for n, e := range expected {
+		if messages[n] != e {
+			t.Errorf("expected '%s', got '%s'", e, messages[n])
+		}
+	}
+}
+
+// Test_Validate_InvalidOutputFile tests that outputs with a file outside of the repository are not valid
+func Test_Validate_InvalidOutputFile(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/040-invalid-output-file")
+	expected := []string{
+		"resource resource-1 of kind test: output absolute: file /etc/passwd must be a relative path",
+		"resource resource-1 of kind test: output unclean: file a//b/../c.yaml must be a clean path (a/c.yaml)",
+		"resource resource-1 of kind test: output escape: file ../c.yaml is outside of the repository",
+		"resource resource-1 of kind test: output unparsable: template: repository:1: unclosed action",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("expected '%s', got '%s'", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Validate_MissingAggregateSelectors tests that an aggregate without selectors is not valid
+func Test_Validate_MissingAggregateSelectors(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/042-missing-aggregate-selectors")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "aggregate aggregate-1 selectors are required" {
+		t.Errorf("expected 'aggregate aggregate-1 selectors are required', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_MissingDependency tests that a resource depending on a resource that does not exist is not valid
+func Test_Validate_MissingDependency(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/044-missing-dependency")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "resource resource-1 of kind test: dependsOn resource missing of kind test does not exist" {
+		t.Errorf("expected 'resource resource-1 of kind test: dependsOn resource missing of kind test does not exist', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_InvalidFormats tests that JSON and TOML documents with invalid fields are not valid
+func Test_Validate_InvalidFormats(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/047-invalid-formats")
+	expected := []string{
+		"testdata/validate/047-invalid-formats/resource-1.json: invalid resource spec field `invalid`",
+		"testdata/validate/047-invalid-formats/template-1.toml: invalid template spec field `invalid`",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for n, err := range errs {
+		if err.Error() != expected[n] {
+			t.Errorf("expected '%s', got '%s'", expected[n], err.Error())
+		}
+	}
+}
+
+// Test_Validate_InvalidNamespace tests that a resource whose namespace contains a slash is not valid
+func Test_Validate_InvalidNamespace(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/053-invalid-namespace")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if errs[0].Error() != "resource resource-1 namespace team-a/dev must not contain /" {
+		t.Errorf("expected 'resource resource-1 namespace team-a/dev must not contain /', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_DanglingReference tests that a resource whose data refers to a resource that does not exist, or has an invalid reference, is not valid
+func Test_Validate_DanglingReference(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/055-dangling-reference")
+	expected := []string{
+		"resource resource-1 of kind test: data.database: resource missing of kind database does not exist",
+		"resource resource-1 of kind test: data.queue: invalid reference field `title`",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for n, e := range expected {
+		if errs[n].Error() != e {
+			t.Errorf("expected '%s', got '%s'", e, errs[n].Error())
+		}
+	}
+}
+
+// Test_Validate_PolicyViolation tests that an Index violating a rule of error severity is not valid
+func Test_Validate_PolicyViolation(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/058-policy-violation").Errors()
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if errs[0].Error() != "policy platform: rule no-github: output:service/orders/config: writes .github/orders.txt under .github/" {
+		t.Errorf("expected 'policy platform: rule no-github: output:service/orders/config: writes .github/orders.txt under .github/', got '%s'", errs[0].Error())
+	}
+}
+
+// Test_Validate_InvalidPolicy tests that a policy with an unknown target or severity is not valid
+func Test_Validate_InvalidPolicy(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/059-invalid-policy")
+	expected := []string{
+		"policy platform: rule service-team: unknown target service",
+		"policy platform: rule service-team: unknown severity fatal",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for n, e := range expected {
+		if errs[n].Error() != e {
+			t.Errorf("expected '%s', got '%s'", e, errs[n].Error())
+		}
+	}
+}
+
+// Test_Validate_InvalidSecret tests that a resource whose data has an invalid secret reference is not valid
+func Test_Validate_InvalidSecret(t *testing.T) {
+	i := NewIndex()
+	errs := i.Load("testdata/validate/063-invalid-secret")
+	expected := []string{
+		"resource resource-1 of kind test: data.password: invalid secret, expected provider and key",
+		"resource resource-1 of kind test: data.token: invalid secret field `name`",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for n, e := range expected {
+		if errs[n].Error() != e {
+			t.Errorf("expected '%s', got '%s'", e, errs[n].Error())
+		}
+	}
+}